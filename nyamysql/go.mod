@@ -10,3 +10,7 @@ require (
 )
 
 require filippo.io/edwards25519 v1.1.0 // indirect
+
+require github.com/kagurazakayashi/libNyaruko_Go/nyasql v0.0.0-00010101000000-000000000000
+
+replace github.com/kagurazakayashi/libNyaruko_Go/nyasql => ../nyasql
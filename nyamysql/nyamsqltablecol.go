@@ -2,6 +2,7 @@
 package nyamysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -21,6 +22,14 @@ type TableColumn struct {
 // 返回值為 TableColumn 型別的切片和一個 error 物件
 // 如果查詢失敗，則返回 nil 和相應的 error 物件
 func (p *NyaMySQL) GetTableStructure(tableName string) ([]TableColumn, error) {
+	return p.GetTableStructureContext(context.Background(), tableName)
+}
+
+// GetTableStructureContext 同 GetTableStructure ，可透過 ctx 取消查詢或設定逾時
+func (p *NyaMySQL) GetTableStructureContext(ctx context.Context, tableName string) ([]TableColumn, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
 	// 構造查詢表結構的SQL語句
 	query := `
 		SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, COLUMN_DEFAULT, EXTRA
@@ -28,27 +37,26 @@ func (p *NyaMySQL) GetTableStructure(tableName string) ([]TableColumn, error) {
 		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`
 
 	// 執行查詢
-	rows, err := p.db.Query(query, tableName)
+	rows, err := p.queryContext(ctx, "GetTableStructure", query, []interface{}{tableName})
 	if err != nil {
 		return nil, err
 	}
 
-	// 確保在函式返回前關閉rows
-	defer rows.Close()
-
 	var columns []TableColumn
-	// 遍歷查詢結果
-	for rows.Next() {
+	// 遍歷查詢結果，結束後關閉rows
+	err = iterateRows(rows, func(r *Row) error {
 		var column TableColumn
 		// 掃描當前行到column變數中
-		err := rows.Scan(&column.ColumnName, &column.ColumnType, &column.IsNullable, &column.ColumnKey, &column.ColumnDefault, &column.Extra)
-		if err != nil {
-			return nil, err
+		if err := r.Scan(&column.ColumnName, &column.ColumnType, &column.IsNullable, &column.ColumnKey, &column.ColumnDefault, &column.Extra); err != nil {
+			return err
 		}
 		// 將當前列新增到columns切片中
 		columns = append(columns, column)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return columns, nil
 }
 
@@ -61,6 +69,14 @@ func (p *NyaMySQL) GetTableStructure(tableName string) ([]TableColumn, error) {
 // 返回值：
 // - error: 如果建立表過程中發生錯誤，則返回錯誤物件；否則返回nil
 func (p *NyaMySQL) CreateTableFromColumns(tableName string, columns []TableColumn) error {
+	return p.CreateTableFromColumnsContext(context.Background(), tableName, columns)
+}
+
+// CreateTableFromColumnsContext 同 CreateTableFromColumns ，可透過 ctx 取消執行或設定逾時
+func (p *NyaMySQL) CreateTableFromColumnsContext(ctx context.Context, tableName string, columns []TableColumn) error {
+	if err := p.check(); err != nil {
+		return err
+	}
 	// 定義列定義和主鍵的切片
 	var columnDefinitions []string
 	var primaryKeys []string
@@ -103,13 +119,6 @@ func (p *NyaMySQL) CreateTableFromColumns(tableName string, columns []TableColum
 	createTableSQL := fmt.Sprintf("CREATE TABLE `%s` (\n  %s\n);", tableName, strings.Join(columnDefinitions, ",\n  "))
 
 	// 執行建立表的 SQL 語句
-	rows, err := p.db.Query(createTableSQL)
-	if err != nil {
-		return err
-	}
-
-	// 延遲關閉結果集
-	defer rows.Close()
-
-	return nil
+	_, err := p.execContext(ctx, "CreateTableFromColumns", createTableSQL, nil)
+	return err
 }
@@ -2,13 +2,12 @@
 package nyamysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
-
-	"github.com/go-sql-driver/mysql"
 )
 
 var (
@@ -30,75 +29,33 @@ var (
 //	    "1":{"id":2,"name":"2"}
 //	}
 func (p *NyaMySQL) QueryDataCMD(sql string, value ...[]interface{}) (map[string]map[string]string, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return map[string]map[string]string{}, p.err
-		}
+	return p.QueryDataCMDContext(context.Background(), sql, value...)
+}
+
+// QueryDataCMDContext: 同 QueryDataCMD ，可透過 `ctx` 取消查詢或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 QueryDataCMD
+func (p *NyaMySQL) QueryDataCMDContext(ctx context.Context, sql string, value ...[]interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
 	}
 	sqls := strings.Split(sql, ";")
 	for i, v := range sqls {
-		if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-			p.debug.Println("[QueryDataCMD]", dbPrintStr(v, value[i]))
+		var val []interface{}
+		if i < len(value) {
+			val = value[i]
 		}
-		if value == nil {
-			if i+1 == len(sqls) {
-				query, err := p.db.Query(v)
-				if err != nil {
-					if p.debug != nil {
-						if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-							p.debug.Println("[QueryDataCMD]", v)
-						}
-						p.debug.Printf("query faied, error:[%v]", err.Error())
-					}
-					return map[string]map[string]string{}, err
-				}
-				return handleQD(query, p.debug)
-			} else {
-				_, err := p.db.Exec(v)
-				if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-					p.debug.Printf("query faied, error:[%v]", err.Error())
-				}
-				return map[string]map[string]string{}, err
-			}
-		} else {
-			stmt, err := p.db.Prepare(v)
+		// 最後一條語句的結果作為查詢結果返回，其餘語句只執行
+		if i+1 == len(sqls) {
+			query, err := p.queryContext(ctx, "QueryDataCMD", v, val)
 			if err != nil {
-				if p.debug != nil {
-					if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-						p.debug.Println("[QueryDataCMD]", dbPrintStr(v, value[i]))
-					}
-					p.debug.Printf("query faied, error:[%v]", err.Error())
-				}
 				return map[string]map[string]string{}, err
 			}
-			val := value[i]
-			if i+1 == len(sqls) {
-				query, err := stmt.Query(val...)
-				stmt.Close()
-				if err != nil {
-					if p.debug != nil {
-						if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-							p.debug.Println("[QueryDataCMD]", dbPrintStr(v, value[i]))
-						}
-						p.debug.Printf("query faied, error:[%v]", err.Error())
-					}
-					return map[string]map[string]string{}, err
-				}
-				return handleQD(query, p.debug)
-			} else {
-				_, err = stmt.Exec(val...)
-				stmt.Close()
-				if err != nil {
-					if p.debug != nil {
-						if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-							p.debug.Println("[QueryDataCMD]", dbPrintStr(v, value[i]))
-						}
-						p.debug.Printf("query faied, error:[%v]", err.Error())
-					}
-					return map[string]map[string]string{}, err
-				}
-			}
+			return handleQD(query, p.debug)
+		}
+		if _, err := p.execContext(ctx, "QueryDataCMD", v, val); err != nil {
+			return map[string]map[string]string{}, err
 		}
 	}
 	return map[string]map[string]string{}, fmt.Errorf("query is null")
@@ -118,17 +75,22 @@ func (p *NyaMySQL) QueryDataCMD(sql string, value ...[]interface{}) (map[string]
 //	    "0":{"id":1,"name":"1"},
 //	    "1":{"id":2,"name":"2"}
 //	}
+//	`join` 中設定了軟刪除（SetSoftDelete）的表會自動排除已刪除的行
 func (p *NyaMySQL) QueryDataJOIN(recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return map[string]map[string]string{}, p.err
-		}
+	return p.QueryDataJOINContext(context.Background(), recn, join, where, orderby, limit, value...)
+}
+
+// QueryDataJOINContext: 同 QueryDataJOIN ，可透過 `ctx` 取消查詢或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 QueryDataJOIN
+func (p *NyaMySQL) QueryDataJOINContext(ctx context.Context, recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
 	}
 	var dbq string = "select " + recn + " from "
-	for i := 0; i < len(join); i++ {
-		dbq += join[i]
-	}
+	joinStr, where := p.softDeleteJOIN(strings.Join(join, ""), where)
+	dbq += joinStr
 	if where != "" {
 		dbq += " where " + where
 	}
@@ -140,7 +102,7 @@ func (p *NyaMySQL) QueryDataJOIN(recn string, join []string, where string, order
 	} else {
 		dbq += " limit " + p.limit
 	}
-	return p.QueryTable(dbq, value...)
+	return p.QueryTableContext(ctx, dbq, value...)
 }
 
 // QueryData: 從SQL資料庫中查詢
@@ -157,14 +119,21 @@ func (p *NyaMySQL) QueryDataJOIN(recn string, join []string, where string, order
 //	    "0":{"id":1,"name":"1"},
 //	    "1":{"id":2,"name":"2"}
 //	}
+//	表設定了軟刪除（SetSoftDelete）時自動排除已刪除的行
 func (p *NyaMySQL) QueryData(recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return map[string]map[string]string{}, p.err
-		}
+	return p.QueryDataContext(context.Background(), recn, table, where, orderby, limit, value...)
+}
+
+// QueryDataContext: 同 QueryData ，可透過 `ctx` 取消查詢或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 QueryData
+func (p *NyaMySQL) QueryDataContext(ctx context.Context, recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
 	}
 	var dbq string = "select " + recn + " from `" + table + "`"
+	where = p.softDeleteWhere(table, table, true, where)
 	if where != "" {
 		dbq += " where " + where
 	}
@@ -176,58 +145,29 @@ func (p *NyaMySQL) QueryData(recn string, table string, where string, orderby st
 	} else {
 		dbq += " limit " + p.limit
 	}
-	return p.QueryTable(dbq, value...)
+	return p.QueryTableContext(ctx, dbq, value...)
 }
 
+// QueryTable: 執行完整的查詢語句
+//
+//	`dbq`		string		完整的SQL查詢語句
+//	`value`		interface{}	查詢條件的值
+//	return 結構同 QueryData
 func (p *NyaMySQL) QueryTable(dbq string, value ...interface{}) (map[string]map[string]string, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return map[string]map[string]string{}, p.err
-		}
-	}
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Println("[QueryTable]", dbPrintStr(dbq, value))
-	}
-	var (
-		query *sql.Rows
-		err   error
-	)
-
-	if value == nil {
-		query, err = p.db.Query(dbq)
-		if err != nil {
-			if p.debug != nil {
-				if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-					p.debug.Println("[QueryTable]", dbPrintStr(dbq, value))
-				}
-				p.debug.Printf("query faied, error:[%v]", err.Error())
-			}
-			return map[string]map[string]string{}, err
-		}
-	} else {
-		stmt, err := p.db.Prepare(dbq)
-		if err != nil {
-			if p.debug != nil {
-				if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-					p.debug.Println("[QueryTable]", dbPrintStr(dbq, value))
-				}
-				p.debug.Printf("query faied, error:[%v]", err.Error())
-			}
-			return map[string]map[string]string{}, err
-		}
+	return p.QueryTableContext(context.Background(), dbq, value...)
+}
 
-		query, err = stmt.Query(value...)
-		stmt.Close()
-		if err != nil {
-			if p.debug != nil {
-				if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-					p.debug.Println("[QueryTable]", dbPrintStr(dbq, value))
-				}
-				p.debug.Printf("query faied, error:[%v]", err.Error())
-			}
-			return map[string]map[string]string{}, err
-		}
+// QueryTableContext: 同 QueryTable ，可透過 `ctx` 取消查詢或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 QueryTable
+func (p *NyaMySQL) QueryTableContext(ctx context.Context, dbq string, value ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
+	}
+	query, err := p.readContext(ctx, "QueryTable", dbq, value)
+	if err != nil {
+		return map[string]map[string]string{}, err
 	}
 	return handleQD(query, p.debug)
 }
@@ -240,14 +180,20 @@ func (p *NyaMySQL) QueryTable(dbq string, value ...interface{}) (map[string]map[
 //	`key`		[]string	需要新增的字段
 //	`values`	...interface{}	額外的新增值，會在values後面新增
 //	return int64 和 error 物件，返回受影响行数,最后插入的 ID
+//	啟用審計（EnableAudit）時在同一交易中寫入審計記錄
 func (p *NyaMySQL) AddRecord(table string, ignore bool, key []string, values ...interface{}) (int64, int64, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return 0, 0, p.err
-		}
+	return p.AddRecordContext(context.Background(), table, ignore, key, values...)
+}
+
+// AddRecordContext: 同 AddRecord ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 AddRecord
+func (p *NyaMySQL) AddRecordContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, int64, error) {
+	if err := p.check(); err != nil {
+		return 0, 0, err
 	}
-	return p.AddOrUpdateRecord(table, ignore, key, []string{}, values...)
+	return p.AddOrUpdateRecordContext(ctx, table, ignore, key, []string{}, values...)
 }
 
 // AddRecordLastInsertId: 向SQL資料庫中新增
@@ -259,13 +205,18 @@ func (p *NyaMySQL) AddRecord(table string, ignore bool, key []string, values ...
 //	`values`	...interface{}	額外的新增值，會在values後面新增
 //	return int64 和 error 物件，返回受影响的行ID
 func (p *NyaMySQL) AddRecordLastInsertId(table string, ignore bool, key []string, values ...interface{}) (int64, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return 0, p.err
-		}
+	return p.AddRecordLastInsertIdContext(context.Background(), table, ignore, key, values...)
+}
+
+// AddRecordLastInsertIdContext: 同 AddRecordLastInsertId ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 AddRecordLastInsertId
+func (p *NyaMySQL) AddRecordLastInsertIdContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
 	}
-	result, debugStr, err := p.addOrUpdateRecord(table, ignore, key, []string{}, values...)
+	result, debugStr, err := p.addOrUpdateRecord(ctx, table, ignore, key, []string{}, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -295,14 +246,20 @@ func (p *NyaMySQL) AddRecordLastInsertId(table string, ignore bool, key []string
 //	`upkey`		[]string	需要更新的字段
 //	`values`	...interface{}	額外的新增值，會在values後面新增
 //	return int64,int64 和 error 物件，返回受影响行数 ,最后插入的 ID
+//	啟用審計（EnableAudit）時在同一交易中寫入審計記錄
 func (p *NyaMySQL) AddOrUpdateRecord(table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return 0, 0, p.err
-		}
+	return p.AddOrUpdateRecordContext(context.Background(), table, ignore, key, upkey, values...)
+}
+
+// AddOrUpdateRecordContext: 同 AddOrUpdateRecord ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 AddOrUpdateRecord
+func (p *NyaMySQL) AddOrUpdateRecordContext(ctx context.Context, table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error) {
+	if err := p.check(); err != nil {
+		return 0, 0, err
 	}
-	result, debugStr, err := p.addOrUpdateRecord(table, ignore, key, upkey, values...)
+	result, debugStr, err := p.addOrUpdateRecord(ctx, table, ignore, key, upkey, values...)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -342,16 +299,30 @@ func (p *NyaMySQL) AddOrUpdateRecord(table string, ignore bool, key []string, up
 //	`upkey`		[]string	需要更新的字段
 //	`values`	...interface{}	額外的新增值，會在values後面新增
 //	return int64 和 error 物件，返回受影响行数
-func (p *NyaMySQL) addOrUpdateRecord(table string, ignore bool, key []string, upkey []string, values ...interface{}) (sql.Result, string, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return nil, "", p.err
-		}
+func (p *NyaMySQL) addOrUpdateRecord(ctx context.Context, table string, ignore bool, key []string, upkey []string, values ...interface{}) (sql.Result, string, error) {
+	if err := p.check(); err != nil {
+		return nil, "", err
 	}
 	if len(values)%len(key) != 0 {
 		return nil, "", fmt.Errorf("'values'内容数量与'key'不符")
 	}
+	dbq := insertSQL(table, ignore, key, upkey, len(values)/len(key))
+	debugKey := "AddRecord"
+	if len(upkey) != 0 {
+		debugKey = "AddOrUpdateRecord"
+	}
+
+	debugStr := fmt.Sprintf("[%s]%s", debugKey, dbPrintStr(dbq, values))
+
+	result, err := p.auditExec(ctx, debugKey, dbq, values, &auditWrite{table: table, key: key, values: values, upsert: len(upkey) != 0})
+	if err != nil {
+		return nil, debugStr, err
+	}
+	return result, debugStr, nil
+}
+
+// insertSQL: 生成插入 rows 行資料的 INSERT 語句，`upkey` 不為空時附加 ON DUPLICATE KEY UPDATE
+func insertSQL(table string, ignore bool, key []string, upkey []string, rows int) string {
 	var dbq string = "insert"
 	if ignore && len(upkey) == 0 {
 		dbq += " IGNORE"
@@ -367,7 +338,7 @@ func (p *NyaMySQL) addOrUpdateRecord(table string, ignore bool, key []string, up
 	length := len(key)
 	dbq += keyStr + ")" + " VALUES "
 	valuesStr := "("
-	for i := range values {
+	for i := 0; i < rows*length; i++ {
 		if i != 0 {
 			if i%length == 0 {
 				valuesStr += "),("
@@ -379,7 +350,6 @@ func (p *NyaMySQL) addOrUpdateRecord(table string, ignore bool, key []string, up
 	}
 	dbq += valuesStr + ")"
 
-	debugKey := "AddRecord"
 	if len(upkey) != 0 {
 		dbq += " AS new ON DUPLICATE KEY UPDATE "
 
@@ -391,38 +361,8 @@ func (p *NyaMySQL) addOrUpdateRecord(table string, ignore bool, key []string, up
 			temp += " `" + v + "`=new.`" + v + "`"
 		}
 		dbq += temp + ";"
-		debugKey = "AddOrUpdateRecord"
-	}
-
-	debugStr := fmt.Sprintf("[%s]%s", debugKey, dbPrintStr(dbq, values))
-
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Printf("%s\n", debugStr)
-	}
-
-	stmt, err := p.db.Prepare(dbq)
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println(debugStr)
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
-		return nil, debugStr, err
-	}
-
-	result, err := stmt.Exec(values...)
-	stmt.Close()
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println(debugStr)
-			}
-			p.debug.Printf("[addOrUpdateRecord]data updated faied, error:[%v]", err.Error())
-		}
-		return nil, debugStr, err
 	}
-	return result, debugStr, nil
+	return dbq
 }
 
 // AOrUOneRowRecord: 向SQL資料庫中新增或更新,一行一行添加返回准确的添加行数与更新行数
@@ -436,12 +376,17 @@ func (p *NyaMySQL) addOrUpdateRecord(table string, ignore bool, key []string, up
 //	`values`	...interface{}	額外的新增值，會在values後面新增
 //	return int64,int64 和 error 物件，返回受影响行数 ,最后插入的 ID
 func (p *NyaMySQL) AOrUOneRowRecord(table string, key []string, upkey []string, noupkey []string, retrykey []string, values ...interface{}) (int64, int64, []int64, error) {
+	return p.AOrUOneRowRecordContext(context.Background(), table, key, upkey, noupkey, retrykey, values...)
+}
+
+// AOrUOneRowRecordContext: 同 AOrUOneRowRecord ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 AOrUOneRowRecord
+func (p *NyaMySQL) AOrUOneRowRecordContext(ctx context.Context, table string, key []string, upkey []string, noupkey []string, retrykey []string, values ...interface{}) (int64, int64, []int64, error) {
 	var lastID []int64 = []int64{}
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return 0, 0, lastID, p.err
-		}
+	if err := p.check(); err != nil {
+		return 0, 0, lastID, err
 	}
 
 	keyLen := len(key)
@@ -458,18 +403,9 @@ func (p *NyaMySQL) AOrUOneRowRecord(table string, key []string, upkey []string,
 		for j := 0; j < keyLen; j++ {
 			val = append(val, values[i*keyLen+j])
 		}
-		result, err := p.aOrUOneRowRecord(table, key, upkey, noupkey, val...)
+		result, err := p.aOrUOneRowRecord(ctx, table, key, upkey, noupkey, val...)
 		if err != nil {
-			sqlErr, ok := err.(*mysql.MySQLError)
-			isForeignKey := false
-			if ok {
-				for _, foreignCode := range ErrForeignCode {
-					if foreignCode == sqlErr.Number {
-						isForeignKey = true
-					}
-				}
-			}
-			if isForeignKey {
+			if isMySQLError(err, ErrForeignCode) {
 				isNoupKeyForeignKey := false
 				for _, v := range retrykey {
 					k := fmt.Sprintf("`%s`", v)
@@ -484,7 +420,7 @@ func (p *NyaMySQL) AOrUOneRowRecord(table string, key []string, upkey []string,
 					}
 				}
 				if isNoupKeyForeignKey {
-					result, err = p.aOrUOneRowRecord(table, key, upkey, noupkey, val...)
+					result, err = p.aOrUOneRowRecord(ctx, table, key, upkey, noupkey, val...)
 				}
 			}
 			if err != nil {
@@ -528,12 +464,9 @@ func (p *NyaMySQL) AOrUOneRowRecord(table string, key []string, upkey []string,
 //	`noupkey`	[]string	字段已有时不更新的key的字段
 //	`values`	...interface{}	額外的新增值，會在values後面新增
 //	return int64 和 error 物件，返回受影响行数
-func (p *NyaMySQL) aOrUOneRowRecord(table string, key []string, upkey []string, noupkey []string, values ...interface{}) (sql.Result, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return nil, p.err
-		}
+func (p *NyaMySQL) aOrUOneRowRecord(ctx context.Context, table string, key []string, upkey []string, noupkey []string, values ...interface{}) (sql.Result, error) {
+	if err := p.check(); err != nil {
+		return nil, err
 	}
 	if len(values) != len(key) {
 		return nil, fmt.Errorf("'values'内容数量与'key'不符")
@@ -577,33 +510,7 @@ func (p *NyaMySQL) aOrUOneRowRecord(table string, key []string, upkey []string,
 		debugKey = "AOrUOneRowRecord"
 	}
 
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Printf("[%s]%s\n", debugKey, dbPrintStr(dbq, values))
-	}
-
-	stmt, err := p.db.Prepare(dbq)
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Printf("[%s]%s\n", debugKey, dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
-		return nil, err
-	}
-
-	result, err := stmt.Exec(values...)
-	stmt.Close()
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Printf("[%s]%s\n", debugKey, dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("[aOrUOneRowRecord]data updated faied, error:[%v]", err.Error())
-		}
-		return nil, err
-	}
-	return result, nil
+	return p.execContext(ctx, debugKey, dbq, values)
 }
 
 // UpdataRecord: 從SQL資料庫中修改指定的值
@@ -614,40 +521,29 @@ func (p *NyaMySQL) aOrUOneRowRecord(table string, key []string, upkey []string,
 //	`where`		string		需要修改行的條件，例:`id`=10
 //	`values`	...interface{}	額外的修改值
 //	return int64 和 error，返回更新的行数
+//	啟用審計（EnableAudit）時在同一交易中寫入審計記錄
 func (p *NyaMySQL) UpdateRecord(table string, updata string, where string, values ...interface{}) (int64, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return 0, p.err
-		}
+	return p.UpdateRecordContext(context.Background(), table, updata, where, values...)
+}
+
+// UpdateRecordContext: 同 UpdateRecord ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 UpdateRecord
+func (p *NyaMySQL) UpdateRecordContext(ctx context.Context, table string, updata string, where string, values ...interface{}) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
 	}
 	var dbq string = "update `" + table + "` set " + updata
 	if where != "" {
 		dbq += " where " + where
 	}
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Println("[UpdataRecord]", dbPrintStr(dbq, values))
+	n := strings.Count(updata, "?")
+	if n > len(values) {
+		n = len(values)
 	}
-
-	stmt, err := p.db.Prepare(dbq)
+	result, err := p.auditExec(ctx, "UpdataRecord", dbq, values, &auditWrite{table: table, where: where, whereValues: values[n:]})
 	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[UpdataRecord]", dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
-		return 0, err
-	}
-	result, err := stmt.Exec(values...)
-	stmt.Close()
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[UpdataRecord]", dbPrintStr(dbq, values))
-			}
-			log.Printf("update faied, error:[%v]", err.Error())
-		}
 		return 0, err
 	}
 	num, _ := result.RowsAffected()
@@ -666,16 +562,23 @@ func (p *NyaMySQL) UpdateRecord(table string, updata string, where string, value
 //	`values`	...interface{}	刪除條件的值
 //	return		int64		刪除的行数
 //	return		error		錯誤
+//	表設定了軟刪除（SetSoftDelete）時改為設定刪除時間，需要真正刪除時使用 ForceDelete
+//	啟用審計（EnableAudit）時在同一交易中寫入審計記錄
 func (p *NyaMySQL) DeleteRecord(table string, key string, and string, values ...interface{}) (int64, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return 0, p.err
-		}
+	return p.DeleteRecordContext(context.Background(), table, key, and, values...)
+}
+
+// DeleteRecordContext: 同 DeleteRecord ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 DeleteRecord
+func (p *NyaMySQL) DeleteRecordContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
 	}
-	var dbq string = fmt.Sprintf("delete from `%s` where `%s`", table, key)
+	var where string = fmt.Sprintf("`%s`", key)
 	if len(values) <= 1 {
-		dbq += fmt.Sprintf("=? %s", and)
+		where += fmt.Sprintf("=? %s", and)
 	} else {
 		wherein := ""
 		length := len(values)
@@ -692,31 +595,12 @@ func (p *NyaMySQL) DeleteRecord(table string, key string, and string, values ...
 			}
 			wherein += "?"
 		}
-		dbq += fmt.Sprintf(" in (%s) %s", wherein, and)
+		where += fmt.Sprintf(" in (%s) %s", wherein, and)
 	}
-	//删除uid=2的数据
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Println("[DeleteRecord]", dbPrintStr(dbq, values))
-	}
-	stmt, err := p.db.Prepare(dbq)
+	//删除uid=2的数据，表設定了軟刪除時改為更新刪除時間
+	dbq := p.deleteSQL(table, where)
+	result, err := p.auditExec(ctx, "DeleteRecord", dbq, values, &auditWrite{table: table, where: where, whereValues: values, delete: true})
 	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[DeleteRecord]", dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
-		return 0, err
-	}
-	result, err := stmt.Exec(values...)
-	stmt.Close()
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[DeleteRecord]", dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("delete faied, error:[%v]", err.Error())
-		}
 		return 0, err
 	}
 	num, _ := result.RowsAffected()
@@ -732,17 +616,22 @@ func (p *NyaMySQL) DeleteRecord(table string, key string, and string, values ...
 //	`table`		string		從哪個表中查詢不需要``包裹
 //	`keys`		[]string	根據哪個關鍵字刪除
 //	`values`	...interface{}	刪除條件的值
+//	表設定了軟刪除（SetSoftDelete）時改為設定刪除時間，需要真正刪除時使用 ForceDelete
 func (p *NyaMySQL) DeleteRecordNoPK(table string, keys []string, values ...interface{}) error {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return p.err
-		}
+	return p.DeleteRecordNoPKContext(context.Background(), table, keys, values...)
+}
+
+// DeleteRecordNoPKContext: 同 DeleteRecordNoPK ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 DeleteRecordNoPK
+func (p *NyaMySQL) DeleteRecordNoPKContext(ctx context.Context, table string, keys []string, values ...interface{}) error {
+	if err := p.check(); err != nil {
+		return err
 	}
 	if len(values)%len(keys) != 0 {
 		return fmt.Errorf("'values'内容数量与'keys'不符")
 	}
-	var dbq string = "delete from `" + table + "` where ("
 	where := ""
 	len := len(values) / len(keys)
 	for i := 0; i < len; i++ {
@@ -756,32 +645,10 @@ func (p *NyaMySQL) DeleteRecordNoPK(table string, keys []string, values ...inter
 			where += "`" + vv + "`=?"
 		}
 	}
-	dbq += where + ")"
-
-	//删除uid=2的数据
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Println("[DeleteRecordNoPK]", dbPrintStr(dbq, values))
-	}
-
-	stmt, err := p.db.Prepare(dbq)
+	//删除uid=2的数据，表設定了軟刪除時改為更新刪除時間
+	dbq := p.deleteSQL(table, "("+where+")")
+	result, err := p.auditExec(ctx, "DeleteRecordNoPK", dbq, values, &auditWrite{table: table, where: "(" + where + ")", whereValues: values, delete: true})
 	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[DeleteRecordNoPK]", dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
-		return err
-	}
-	result, err := stmt.Exec(values...)
-	stmt.Close()
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[DeleteRecordNoPK]", dbPrintStr(dbq, values))
-			}
-			p.debug.Printf("delete faied, error:[%v]", err.Error())
-		}
 		return err
 	}
 	num, _ := result.RowsAffected()
@@ -803,79 +670,185 @@ func (p *NyaMySQL) DeleteRecordNoPK(table string, keys []string, values ...inter
 //	    "1":{"id":2,"name":"2"}
 //	}
 func (p *NyaMySQL) FreequeryData(sqlstr string, values ...interface{}) (map[string]map[string]string, error) {
-	if p == nil {
-		p = NewC(parametersSave.Config, parametersSave.Debug, parametersSave.loggerLevel)
-		if p.Error() != nil {
-			return map[string]map[string]string{}, p.err
-		}
-	}
-	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
-		p.debug.Println("[FreequeryData]", dbPrintStr(sqlstr, values))
-	}
-	stmt, err := p.db.Prepare(sqlstr)
-	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[FreequeryData]", dbPrintStr(sqlstr, values))
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
+	return p.FreequeryDataContext(context.Background(), sqlstr, values...)
+}
+
+// FreequeryDataContext: 同 FreequeryData ，可透過 `ctx` 取消查詢或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 FreequeryData
+func (p *NyaMySQL) FreequeryDataContext(ctx context.Context, sqlstr string, values ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
 		return map[string]map[string]string{}, err
 	}
-	query, err := stmt.Query(values...)
-	stmt.Close()
+	query, err := p.readContext(ctx, "FreequeryData", sqlstr, values)
 	if err != nil {
-		if p.debug != nil {
-			if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-				p.debug.Println("[FreequeryData]", dbPrintStr(sqlstr, values))
-			}
-			p.debug.Printf("query faied, error:[%v]", err.Error())
-		}
 		return map[string]map[string]string{}, err
 	}
+	return handleQD(query, p.debug)
+}
 
-	//读出查询出的列字段名
-	cols, _ := query.Columns()
-	//values是每个列的值，这里获取到byte里
-	vals := make([][]byte, len(cols))
-	//query.Scan的参数，因为每次查询出来的列是不定长的，用len(cols)定住当次查询的长度
-	scans := make([]interface{}, len(cols))
-	//让每一行数据都填充到[][]byte里面
-	for i := range vals {
-		scans[i] = &vals[i]
+// QueryRows: 執行查詢並直接返回結果集，供需要自行掃描結果的呼叫方使用
+//
+//	`dbq`		string		完整的SQL查詢語句
+//	`values`	...interface{}	查詢條件的值
+//	return *sql.Rows 和 error，結果集使用完畢後必須關閉
+func (p *NyaMySQL) QueryRows(dbq string, values ...interface{}) (*sql.Rows, error) {
+	return p.QueryRowsContext(context.Background(), dbq, values...)
+}
+
+// QueryRowsContext: 同 QueryRows ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaMySQL) QueryRowsContext(ctx context.Context, dbq string, values ...interface{}) (*sql.Rows, error) {
+	if err := p.check(); err != nil {
+		return nil, err
 	}
+	return p.readContext(ctx, "QueryRows", dbq, values)
+}
 
-	//最后得到的map
-	results := map[string]map[string]string{}
-	i := 0
-	for query.Next() { //循环，让游标往下推
-		if err := query.Scan(scans...); err != nil { //query.Scan查询出来的不定长值放到scans[i] = &values[i],也就是每行都放在values里
-			if p.debug != nil {
-				if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
-					p.debug.Println("[FreequeryData]", dbPrintStr(sqlstr, values))
-				}
-				p.debug.Println(err)
-			}
-			return map[string]map[string]string{}, err
+// sqlConn 是 *sql.DB 與 *sql.Tx 共同的執行介面
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// conn: 返回目前應使用的連線，交易中返回交易本身
+func (p *NyaMySQL) conn() sqlConn {
+	if p.tx != nil {
+		return p.tx
+	}
+	return p.db
+}
+
+// queryContext: 執行查詢語句並返回結果集，有引數時先預處理語句
+//
+//	`tag`		string		除錯日誌中的標記
+//	`dbq`		string		SQL語句
+//	`values`	[]interface{}	語句中的值
+//	return *sql.Rows 和 error，結果集需由呼叫方關閉
+func (p *NyaMySQL) queryContext(ctx context.Context, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	return p.queryOn(ctx, p.conn(), tag, dbq, values)
+}
+
+// queryOn: 同 queryContext ，在指定的連線上執行，遇到暫時性錯誤時按重試策略重試
+func (p *NyaMySQL) queryOn(ctx context.Context, c sqlConn, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	var query *sql.Rows
+	err := p.withRetry(ctx, false, func() error {
+		var err error
+		query, err = p.queryOnce(ctx, c, tag, dbq, values)
+		return err
+	})
+	return query, err
+}
+
+// queryOnce: 在指定的連線上執行一次查詢
+func (p *NyaMySQL) queryOnce(ctx context.Context, c sqlConn, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	ctx, e, hooks := p.beforeHooks(ctx, tag, dbq, values, false)
+	query, err := p.queryStmt(ctx, c, tag, dbq, values)
+	afterHooks(ctx, e, hooks, -1, err)
+	return query, err
+}
+
+// queryStmt: 執行查詢，有引數時使用快取的預處理語句
+func (p *NyaMySQL) queryStmt(ctx context.Context, c sqlConn, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	p.logSQL(tag, dbq, values)
+	if len(values) == 0 {
+		query, err := c.QueryContext(ctx, dbq)
+		if err != nil {
+			p.logErr(tag, dbq, values, err)
+			return nil, err
 		}
-		row := map[string]string{} //每行数据
-		for k, v := range vals {   //每行数据是放在values里面，现在把它挪到row里
-			key := cols[k]
-			row[key] = string(v)
+		return query, nil
+	}
+	stmt, release, err := p.prepare(ctx, c, dbq)
+	if err != nil {
+		p.logErr(tag, dbq, values, err)
+		return nil, err
+	}
+	query, err := stmt.QueryContext(ctx, values...)
+	release(err)
+	if err != nil {
+		p.logErr(tag, dbq, values, err)
+		return nil, err
+	}
+	return query, nil
+}
+
+// execContext: 執行不返回結果集的語句，有引數時先預處理語句
+//
+//	`tag`		string		除錯日誌中的標記
+//	`dbq`		string		SQL語句
+//	`values`	[]interface{}	語句中的值
+//	return sql.Result 和 error
+func (p *NyaMySQL) execContext(ctx context.Context, tag string, dbq string, values []interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := p.withRetry(ctx, true, func() error {
+		var err error
+		result, err = p.execOnce(ctx, p.conn(), tag, dbq, values)
+		return err
+	})
+	return result, err
+}
+
+// execOnce: 在指定的連線上執行一次不返回結果集的語句
+func (p *NyaMySQL) execOnce(ctx context.Context, c sqlConn, tag string, dbq string, values []interface{}) (sql.Result, error) {
+	ctx, e, hooks := p.beforeHooks(ctx, tag, dbq, values, true)
+	result, err := p.execStmt(ctx, c, tag, dbq, values)
+	var rowsAffected int64 = -1
+	if e != nil && err == nil {
+		rowsAffected, _ = result.RowsAffected()
+	}
+	afterHooks(ctx, e, hooks, rowsAffected, err)
+	return result, err
+}
+
+// execStmt: 執行不返回結果集的語句，有引數時使用快取的預處理語句
+func (p *NyaMySQL) execStmt(ctx context.Context, c sqlConn, tag string, dbq string, values []interface{}) (sql.Result, error) {
+	p.logSQL(tag, dbq, values)
+	if len(values) == 0 {
+		result, err := c.ExecContext(ctx, dbq)
+		if err != nil {
+			p.logErr(tag, dbq, values, err)
+			return nil, err
 		}
-		results[strconv.Itoa(i)] = row //装入结果集中
-		i++
+		return result, nil
+	}
+	stmt, release, err := p.prepare(ctx, c, dbq)
+	if err != nil {
+		p.logErr(tag, dbq, values, err)
+		return nil, err
 	}
+	result, err := stmt.ExecContext(ctx, values...)
+	release(err)
+	if err != nil {
+		p.logErr(tag, dbq, values, err)
+		return nil, err
+	}
+	return result, nil
+}
 
-	//关闭结果集（释放连接）
-	query.Close()
-	// log.Println("-----====-----")
+// logSQL: 除錯模式下輸出即將執行的語句
+func (p *NyaMySQL) logSQL(tag string, dbq string, values []interface{}) {
+	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
+		p.debug.Println("["+tag+"]", dbPrintStr(dbq, values))
+	}
+}
 
-	return results, nil
+// logErr: 輸出執行失敗的錯誤，錯誤模式下同時輸出出錯的語句
+func (p *NyaMySQL) logErr(tag string, dbq string, values []interface{}, err error) {
+	if p.debug == nil {
+		return
+	}
+	if p.loggerLevel == NYAMYSQL_LOG_LEVEL_ERROR {
+		p.debug.Println("["+tag+"]", dbPrintStr(dbq, values))
+	}
+	p.debug.Printf("[%s]query faied, error:[%v]", tag, err.Error())
 }
 
 // handleQD: 處理查詢結果
 func handleQD(query *sql.Rows, Deubg *log.Logger) (map[string]map[string]string, error) {
+	//关闭结果集（释放连接）
+	defer query.Close()
 	//读出查询出的列字段名
 	cols, _ := query.Columns()
 	//values是每个列的值，这里获取到byte里
@@ -905,10 +878,12 @@ func handleQD(query *sql.Rows, Deubg *log.Logger) (map[string]map[string]string,
 		results[strconv.Itoa(i)] = row //装入结果集中
 		i++
 	}
-
-	//关闭结果集（释放连接）
-	query.Close()
-	// log.Println("-----====-----")
+	if err := query.Err(); err != nil {
+		if Deubg != nil {
+			Deubg.Println(err)
+		}
+		return map[string]map[string]string{}, err
+	}
 
 	return results, nil
 }
@@ -1,10 +1,28 @@
 package nyamysql_test
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/kagurazakayashi/libNyaruko_Go/nyamysql"
+	"github.com/kagurazakayashi/libNyaruko_Go/nyamysql/nyamysqltest"
+	"github.com/kagurazakayashi/libNyaruko_Go/nyasql"
+	"gopkg.in/yaml.v3"
+)
+
+// NyaMySQL 和 Tx 的方法簽名改變時在編譯時發現，而不是在使用者切換資料庫時
+var (
+	_ nyasql.DB      = (*nyamysql.NyaMySQL)(nil)
+	_ nyasql.Querier = (*nyamysql.Tx)(nil)
 )
 
 var mysqlconfig string = `{
@@ -51,6 +69,102 @@ func TestQueryData(t *testing.T) {
 	fmt.Println(qd)
 }
 
+func TestQueryDataContext(t *testing.T) {
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	qd, err := nyaMS.QueryDataContext(ctx, "*", "test", "`id`=?", "`id` asc", "0,10", 1)
+	if err != nil {
+		fmt.Println("QueryDataContext error:", err.Error())
+		return
+	}
+	fmt.Println(qd)
+
+	// 已取消的 ctx 不應再執行查詢
+	cancel()
+	if _, err := nyaMS.QueryDataContext(ctx, "*", "test", "", "", ""); err == nil {
+		t.Error("QueryDataContext with canceled context should fail")
+	}
+}
+
+// scanBase 用於測試嵌入結構體與外層欄位同名時的優先順序
+type scanBase struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestQueryInto(t *testing.T) {
+	// 嵌入結構體宣告在前時，外層的同名欄位仍然優先
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.ExpectQuery("^select `id`,`name` from `test`$").WillReturnRows([]string{"id", "name"}, []interface{}{1, "nya"})
+	type outer struct {
+		scanBase
+		Name string `db:"name"`
+	}
+	got, err := nyamysql.QueryInto[outer](fake, "select `id`,`name` from `test`")
+	if err != nil || len(got) != 1 || got[0].ID != 1 || got[0].Name != "nya" || got[0].scanBase.Name != "" {
+		t.Errorf("QueryInto = %+v, %v", got, err)
+	}
+
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	type testRow struct {
+		ID   int64          `db:"id"`
+		Name sql.NullString `db:"name"`
+	}
+	rows, err := nyamysql.QueryInto[testRow](nyaMS, "select `id`,`name` from `test` where `id`>? order by `id`", 0)
+	if err != nil {
+		fmt.Println("QueryInto error:", err.Error())
+		return
+	}
+	fmt.Println(rows)
+
+	// 結構體中沒有對應欄位的列應返回 ColumnMappingError
+	_, err = nyamysql.QueryInto[struct {
+		ID int64 `db:"id"`
+	}](nyaMS, "select `id`,`name` from `test`")
+	var mapErr *nyamysql.ColumnMappingError
+	if !errors.As(err, &mapErr) {
+		t.Errorf("expected ColumnMappingError, got %v", err)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	count := 0
+	err := nyaMS.Iterate(context.Background(), "select * from `test`", nil, func(row *nyamysql.Row) error {
+		m, err := row.Map()
+		if err != nil {
+			return err
+		}
+		fmt.Println(row.Index(), m)
+		count++
+		if count == 2 {
+			return nyamysql.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Iterate error:", err.Error())
+		return
+	}
+	if count > 2 {
+		t.Errorf("Iterate should stop after ErrStopIteration, got %d rows", count)
+	}
+}
+
 func TestAdd(t *testing.T) {
 	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
 	if nyaMS.Error() != nil {
@@ -66,6 +180,36 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+func TestWithTx(t *testing.T) {
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	key := []string{"id", "name"}
+	err := nyaMS.WithTx(func(tx *nyamysql.Tx) error {
+		if _, _, err := tx.AddRecord("test", false, key, 10, "tx1"); err != nil {
+			return err
+		}
+		// 巢狀交易失敗只回滾到儲存點
+		nestedErr := tx.WithTx(func(tx *nyamysql.Tx) error {
+			if _, _, err := tx.AddRecord("test", false, key, 11, "tx2"); err != nil {
+				return err
+			}
+			return fmt.Errorf("rollback nested")
+		})
+		if nestedErr == nil {
+			t.Error("nested WithTx should return the error of fn")
+		}
+		_, err := tx.DeleteRecord("test", "id", "", 10)
+		return err
+	})
+	if err != nil {
+		fmt.Println("WithTx error:", err.Error())
+		return
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
 	if nyaMS.Error() != nil {
@@ -112,3 +256,774 @@ func TestDeleteNoPK(t *testing.T) {
 		return
 	}
 }
+
+func TestPool(t *testing.T) {
+	pool := nyamysql.NewPool(mysqlconfig, 1)
+	if pool.Error() != nil {
+		t.Fatal(pool.Error())
+	}
+	if _, err := pool.Acquire(context.Background(), "none"); !errors.Is(err, nyamysql.ErrPoolNotFound) {
+		t.Errorf("expected ErrPoolNotFound, got %v", err)
+	}
+	nyaMS, err := pool.Acquire(context.Background(), nyamysql.DefaultPoolName)
+	if err != nil {
+		fmt.Println("MySQL DB Link error:", err.Error())
+	} else {
+		// 達到上限時再次借出應等待直到逾時
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		if _, err := pool.Acquire(ctx, nyamysql.DefaultPoolName); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected DeadlineExceeded, got %v", err)
+		}
+		cancel()
+		fmt.Println(nyaMS.QueryData("*", "test", "", "", ""))
+		pool.Release(nyamysql.DefaultPoolName)
+		stats, _ := pool.Stats(nyamysql.DefaultPoolName)
+		fmt.Printf("%+v\n", stats)
+	}
+	pool.Close()
+	if _, err := pool.Acquire(context.Background(), nyamysql.DefaultPoolName); !errors.Is(err, nyamysql.ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPoolInvalidConfig(t *testing.T) {
+	// 配置無法解析時連線池的方法應返回錯誤而不是 panic
+	pool := nyamysql.NewPool("not json", 1)
+	if pool.Error() == nil {
+		t.Fatal("expected a parse error")
+	}
+	if err := pool.Register("other", nyamysql.MySQLDBConfig{}, 1); err != pool.Error() {
+		t.Errorf("Register = %v, want %v", err, pool.Error())
+	}
+	if _, err := pool.Acquire(context.Background(), nyamysql.DefaultPoolName); err != pool.Error() {
+		t.Errorf("Acquire = %v, want %v", err, pool.Error())
+	}
+	pool.Release(nyamysql.DefaultPoolName)
+	pool.Close()
+	pool.Close()
+
+	// 連線時同樣遵守 ctx
+	pool = nyamysql.NewPoolC(nyamysql.MySQLDBConfig{Address: "127.0.0.1", Port: "3306"}, 1)
+	defer pool.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Acquire(ctx, nyamysql.DefaultPoolName); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Canceled, got %v", err)
+	}
+	if stats, _ := pool.Stats(nyamysql.DefaultPoolName); stats.InUse != 0 || stats.Open {
+		t.Errorf("unexpected stats after a canceled Acquire: %+v", stats)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	var config nyamysql.MySQLDBConfig
+	if err := json.Unmarshal([]byte(`{
+	"mysql_addr": "127.0.0.1",
+	"mysql_port": "3306",
+	"mysql_replicas": [
+		{"mysql_addr": "127.0.0.1", "mysql_port": "3307"},
+		{"mysql_addr": "127.0.0.1", "mysql_port": "3308", "mysql_user": "reader"}
+	]
+}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Replicas) != 2 || config.Replicas[1].User != "reader" {
+		t.Fatalf("unexpected replicas: %+v", config.Replicas)
+	}
+	nyaMS := nyamysql.NewC(config, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	// 副本不可用時查詢應回退到主庫
+	if _, err := nyaMS.QueryData("*", "test", "", "", ""); err != nil {
+		fmt.Println("QueryData error:", err.Error())
+	}
+	if _, err := nyaMS.ForcePrimary().QueryData("*", "test", "", "", ""); err != nil {
+		fmt.Println("QueryData error:", err.Error())
+	}
+	fmt.Printf("%+v\n", nyaMS.ReplicaStats())
+}
+
+func TestNotConnected(t *testing.T) {
+	// nil 實例不再自動以上一次的配置重新連線
+	var nyaMS *nyamysql.NyaMySQL
+	if _, err := nyaMS.QueryData("*", "test", "", "", ""); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	if _, err := nyaMS.Begin(); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	// 已關閉的實例返回 ErrNotConnected 而不是 panic
+	fake := nyamysqltest.NewFake()
+	fake.ExpectQuery("INFORMATION_SCHEMA.COLUMNS").WithArgs("test").
+		WillReturnRows([]string{"COLUMN_NAME", "COLUMN_TYPE", "IS_NULLABLE", "COLUMN_KEY", "COLUMN_DEFAULT", "EXTRA"},
+			[]interface{}{"id", "int", "NO", "PRI", nil, "auto_increment"})
+	fake.ExpectExec("^CREATE TABLE `test_copy`")
+	if cols, err := fake.GetTableStructure("test"); err != nil || len(cols) != 1 || cols[0].ColumnKey != "PRI" {
+		t.Errorf("GetTableStructure = %+v, %v", cols, err)
+	} else if err := fake.CreateTableFromColumns("test_copy", cols); err != nil {
+		t.Errorf("CreateTableFromColumns = %v", err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	fake.Close()
+	if _, err := fake.GetTableStructure("test"); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("GetTableStructure after Close = %v", err)
+	}
+	if err := fake.CreateTableFromColumns("test", nil); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("CreateTableFromColumns after Close = %v", err)
+	}
+	if err := fake.Ping(); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("Ping after Close = %v", err)
+	}
+
+	if !nyamysql.IsTransientError(&mysql.MySQLError{Number: 1213}) {
+		t.Error("1213 should be transient")
+	}
+	if nyamysql.IsTransientError(&mysql.MySQLError{Number: 1452}) {
+		t.Error("1452 should not be transient")
+	}
+}
+
+func TestDeadlockRetry(t *testing.T) {
+	if !nyamysql.IsDeadlockError(fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1205})) {
+		t.Error("wrapped 1205 should be a deadlock error")
+	}
+	if nyamysql.IsDeadlockError(&mysql.MySQLError{Number: 1040}) {
+		t.Error("1040 should not be a deadlock error")
+	}
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	policy := nyamysql.DefaultRetryPolicy()
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		fmt.Println("retry", attempt, err, wait)
+	}
+	nyaMS.SetRetryPolicy(policy)
+	attempts := 0
+	err := nyaMS.WithTx(func(tx *nyamysql.Tx) error {
+		attempts++
+		if attempts == 1 {
+			return &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("WithTx should retry once after deadlock, attempts=%d err=%v", attempts, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	migrations := fstest.MapFS{
+		"migrations/0002_add_age.up.sql":        {Data: []byte("ALTER TABLE `mig_users` ADD `age` INT;")},
+		"migrations/0002_add_age.down.sql":      {Data: []byte("ALTER TABLE `mig_users` DROP `age`;")},
+		"migrations/0001_create_users.up.sql":   {Data: []byte("-- users; table\nCREATE TABLE `mig_users` (`id` INT PRIMARY KEY, `name` VARCHAR(32) DEFAULT 'a;b');\n/* seed; */ INSERT INTO `mig_users` VALUES (1, \"x\\\";y\");\n")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE `mig_users`;")},
+		"migrations/README.md":                  {Data: []byte("ignored")},
+	}
+	list, err := nyamysql.LoadMigrations(migrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Version != 1 || list[0].Name != "create_users" || list[1].Version != 2 {
+		t.Errorf("unexpected migrations: %+v", list)
+	}
+	if stmts := list[0].UpStatements(); len(stmts) != 2 || !strings.HasSuffix(stmts[0], "'a;b')") || !strings.HasSuffix(stmts[1], "\"x\\\";y\")") {
+		t.Errorf("unexpected statements: %q", stmts)
+	}
+	if _, err := nyamysql.LoadMigrations(fstest.MapFS{"0001_x.down.sql": {Data: []byte("SELECT 1")}}, "."); err == nil {
+		t.Error("migration without up script should fail")
+	}
+	// 修改降級腳本也應改變校驗值
+	edited := fstest.MapFS{}
+	for name, file := range migrations {
+		edited[name] = file
+	}
+	edited["migrations/0002_add_age.down.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE `mig_users` DROP COLUMN `age`;")}
+	if list2, err := nyamysql.LoadMigrations(edited, "migrations"); err != nil || list2[1].Checksum == list[1].Checksum || list2[0].Checksum != list[0].Checksum {
+		t.Errorf("down script edits should change only that checksum: %v", err)
+	}
+
+	// 實例關閉後應返回錯誤而不是 panic
+	fake := nyamysqltest.NewFake()
+	fm, err := fake.NewMigrator(migrations, "migrations", nyamysql.MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 遷移的語句、記錄表和鎖同樣經過鉤子
+	fake.Lenient = true
+	fake.ExpectQuery("^SELECT DATABASE\\(\\)$").WillReturnRows([]string{"DATABASE()"}, []interface{}{"test"})
+	fake.ExpectQuery("^SELECT GET_LOCK").WithArgs("nyamysql:test.schema_migrations", nyamysqltest.AnyArg).WillReturnRows([]string{"GET_LOCK"}, []interface{}{1})
+	fake.ExpectQuery("INFORMATION_SCHEMA.TABLES").WillReturnRows([]string{"COUNT(*)"}, []interface{}{0})
+	tags := map[string]int{}
+	var tagsMu sync.Mutex
+	fake.AddHook(nyamysql.HookFuncs{AfterFunc: func(ctx context.Context, e *nyamysql.QueryEvent) {
+		tagsMu.Lock()
+		tags[e.Tag]++
+		tagsMu.Unlock()
+	}})
+	if done, err := fm.Up(context.Background()); err != nil || len(done) != 2 {
+		t.Errorf("Up = %v, %v", done, err)
+	}
+	// Migrate: DATABASE 、GET_LOCK 、CREATE TABLE 、INFORMATION_SCHEMA 、RELEASE_LOCK ；MigrateUp: 3 條語句和 2 條記錄
+	if tags["Migrate"] != 5 || tags["MigrateUp"] != 5 {
+		t.Errorf("hooks should see every migration statement, got %v", tags)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	fake.Close()
+	if _, err := fm.Status(context.Background()); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("Status after Close = %v", err)
+	}
+	if err := fm.Verify(context.Background()); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("Verify after Close = %v", err)
+	}
+	if _, err := fm.Up(context.Background()); !errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Errorf("Up after Close = %v", err)
+	}
+
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	var plan strings.Builder
+	dry, err := nyaMS.NewMigrator(migrations, "migrations", nyamysql.MigrateOptions{DryRun: &plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dry.Up(context.Background()); err != nil {
+		fmt.Println("Migrate dry-run error:", err.Error())
+	}
+	fmt.Print(plan.String())
+	m, err := nyaMS.NewMigrator(migrations, "migrations", nyamysql.MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		fmt.Println("Migrate up error:", err.Error())
+	}
+	if _, err := m.Down(context.Background(), 2); err != nil {
+		fmt.Println("Migrate down error:", err.Error())
+	}
+}
+
+func TestTableSchema(t *testing.T) {
+	schema := nyamysql.TableSchema{
+		Name:      "users",
+		Engine:    "InnoDB",
+		Charset:   "utf8mb4",
+		Collation: "utf8mb4_general_ci",
+		Comment:   "使用者",
+		Columns: []nyamysql.SchemaColumn{
+			{TableColumn: nyamysql.TableColumn{ColumnName: "id", ColumnType: "int unsigned", IsNullable: "NO", ColumnKey: "PRI", Extra: "auto_increment"}},
+			{TableColumn: nyamysql.TableColumn{ColumnName: "name", ColumnType: "varchar(32)", IsNullable: "NO", ColumnDefault: sql.NullString{String: "it's", Valid: true}}, CharacterSet: "utf8mb4", Collation: "utf8mb4_bin", Comment: "名稱"},
+			{TableColumn: nyamysql.TableColumn{ColumnName: "score", ColumnType: "decimal(5,2)", IsNullable: "YES", ColumnDefault: sql.NullString{String: "0.00", Valid: true}}},
+			{TableColumn: nyamysql.TableColumn{ColumnName: "token", ColumnType: "char(36)", IsNullable: "YES", ColumnDefault: sql.NullString{String: "uuid()", Valid: true}, Extra: "DEFAULT_GENERATED"}},
+			{TableColumn: nyamysql.TableColumn{ColumnName: "updated_at", ColumnType: "datetime(3)", IsNullable: "NO", ColumnDefault: sql.NullString{String: "CURRENT_TIMESTAMP(3)", Valid: true}, Extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP(3)"}},
+		},
+		Indexes: []nyamysql.TableIndex{
+			{Name: "PRIMARY", Unique: true, Type: "BTREE", Columns: []nyamysql.IndexColumn{{Name: "id"}}},
+			{Name: "idx_name", Type: "BTREE", Columns: []nyamysql.IndexColumn{{Name: "name", SubPart: 8}, {Name: "score", Desc: true}}},
+			{Name: "idx_abs", Type: "BTREE", Columns: []nyamysql.IndexColumn{{Expression: "abs(`score`)", Desc: true}, {Name: "id"}}},
+		},
+		ForeignKeys: []nyamysql.ForeignKey{
+			{Name: "fk_group", Columns: []string{"id"}, RefTable: "groups", RefColumns: []string{"user_id"}, OnUpdate: "RESTRICT", OnDelete: "CASCADE"},
+		},
+	}
+	want := "CREATE TABLE `users` (\n" +
+		"  `id` int unsigned NOT NULL auto_increment,\n" +
+		"  `name` varchar(32) COLLATE utf8mb4_bin NOT NULL DEFAULT 'it''s' COMMENT '名稱',\n" +
+		"  `score` decimal(5,2) DEFAULT 0.00,\n" +
+		"  `token` char(36) DEFAULT (uuid()),\n" +
+		"  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) on update CURRENT_TIMESTAMP(3),\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `idx_name` (`name`(8),`score` DESC),\n" +
+		"  KEY `idx_abs` ((abs(`score`)) DESC,`id`),\n" +
+		"  CONSTRAINT `fk_group` FOREIGN KEY (`id`) REFERENCES `groups` (`user_id`) ON DELETE CASCADE\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='使用者'"
+	if got := schema.CreateTableSQL(); got != want {
+		t.Errorf("CreateTableSQL:\n%s\nwant:\n%s", got, want)
+	}
+
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	live, err := nyaMS.GetTableSchema("test")
+	if err != nil {
+		fmt.Println("GetTableSchema error:", err.Error())
+		return
+	}
+	fmt.Println(live.CreateTableSQL())
+}
+
+func TestDiffSchema(t *testing.T) {
+	col := func(name, typ, nullable string) nyamysql.SchemaColumn {
+		return nyamysql.SchemaColumn{TableColumn: nyamysql.TableColumn{ColumnName: name, ColumnType: typ, IsNullable: nullable}}
+	}
+	pk := nyamysql.TableIndex{Name: "PRIMARY", Unique: true, Type: "BTREE", Columns: []nyamysql.IndexColumn{{Name: "id"}}}
+	current := []nyamysql.TableSchema{
+		{Name: "users", Engine: "InnoDB", Columns: []nyamysql.SchemaColumn{col("id", "int", "NO"), col("name", "varchar(16)", "YES"), col("legacy", "int", "YES")},
+			Indexes: []nyamysql.TableIndex{pk, {Name: "idx_name", Type: "BTREE", Columns: []nyamysql.IndexColumn{{Name: "name"}}}}},
+		{Name: "old_logs", Engine: "InnoDB", Columns: []nyamysql.SchemaColumn{col("id", "int", "NO")}},
+		{Name: "tags", Engine: "InnoDB", Columns: []nyamysql.SchemaColumn{col("a", "int", "YES"), col("b", "int", "YES"), col("c", "int", "YES")}},
+	}
+	target := []nyamysql.TableSchema{
+		{Name: "users", Engine: "InnoDB", Columns: []nyamysql.SchemaColumn{col("id", "int", "NO"), col("email", "varchar(64)", "YES"), col("name", "varchar(32)", "YES")},
+			Indexes: []nyamysql.TableIndex{pk, {Name: "idx_name", Unique: true, Type: "BTREE", Columns: []nyamysql.IndexColumn{{Name: "name"}}},
+				{Name: "idx_lower", Type: "BTREE", Columns: []nyamysql.IndexColumn{{Expression: "lower(`email`)"}}}}},
+		{Name: "posts", Engine: "InnoDB", Columns: []nyamysql.SchemaColumn{col("id", "int", "NO"), col("user_id", "int", "NO")}, Indexes: []nyamysql.TableIndex{pk},
+			ForeignKeys: []nyamysql.ForeignKey{{Name: "fk_user", Columns: []string{"user_id"}, RefTable: "users", RefColumns: []string{"id"}}}},
+		{Name: "tags", Engine: "InnoDB", Columns: []nyamysql.SchemaColumn{col("b", "int", "YES"), col("x", "int", "YES"), col("a", "bigint", "YES")}},
+	}
+	diff := nyamysql.DiffTableSchemas(current, target)
+	want := []string{
+		// 列順序 [a b c] -> [b x a]
+		"ALTER TABLE `tags` ADD COLUMN `x` int AFTER `b`",
+		"ALTER TABLE `tags` MODIFY COLUMN `b` int FIRST",
+		"ALTER TABLE `tags` MODIFY COLUMN `x` int AFTER `b`",
+		"ALTER TABLE `tags` MODIFY COLUMN `a` bigint",
+		"ALTER TABLE `tags` DROP COLUMN `c`",
+		"DROP INDEX `idx_name` ON `users`",
+		"ALTER TABLE `users` ADD COLUMN `email` varchar(64) AFTER `id`",
+		"ALTER TABLE `users` MODIFY COLUMN `name` varchar(32)",
+		"CREATE UNIQUE INDEX `idx_name` ON `users` (`name`)",
+		"CREATE INDEX `idx_lower` ON `users` ((lower(`email`)))",
+		"ALTER TABLE `users` DROP COLUMN `legacy`",
+	}
+	create := "CREATE TABLE `posts` (\n  `id` int NOT NULL,\n  `user_id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB"
+	want = append([]string{create}, want...)
+	want = append(want, "ALTER TABLE `posts` ADD CONSTRAINT `fk_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`)", "DROP TABLE `old_logs`")
+	got := diff.Statements()
+	if strings.Join(got, ";\n") != strings.Join(want, ";\n") {
+		t.Errorf("DiffTableSchemas:\n%s\nwant:\n%s", strings.Join(got, ";\n"), strings.Join(want, ";\n"))
+	}
+	if n := len(diff.Destructive()); n != 5 {
+		t.Errorf("expected 5 destructive changes, got %d: %+v", n, diff.Destructive())
+	}
+	if !nyamysql.DiffTableSchemas(target, target).Empty() {
+		t.Error("identical schemas should have no changes")
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	if _, err := nyaMS.BulkInsert(context.Background(), "test", []string{"a", "b"}, []interface{}{1, 2, 3}, nyamysql.BulkOptions{}); err == nil {
+		t.Error("mismatched values should fail")
+	}
+	values := []interface{}{}
+	for i := 0; i < 2500; i++ {
+		values = append(values, i, fmt.Sprintf("name%d", i))
+	}
+	result, err := nyaMS.BulkInsert(context.Background(), "test", []string{"id", "name"}, values, nyamysql.BulkOptions{
+		Ignore:  true,
+		MaxRows: 1000,
+		Workers: 2,
+		OnProgress: func(progress nyamysql.BulkProgress) {
+			fmt.Printf("%d/%d chunks, %d/%d rows\n", progress.ChunksDone, progress.ChunksTotal, progress.RowsDone, progress.RowsTotal)
+		},
+	})
+	if err != nil {
+		fmt.Println("BulkInsert error:", err.Error())
+	}
+	if result != nil && len(result.Chunks) != 3 {
+		t.Errorf("expected 3 chunks, got %d", len(result.Chunks))
+	}
+}
+
+func TestUpdateVersioned(t *testing.T) {
+	var staleErr error = &nyamysql.StaleVersionError{Table: "test", Expected: 3, CurrentVersion: 4}
+	if !errors.Is(fmt.Errorf("save: %w", staleErr), nyamysql.ErrStaleVersion) {
+		t.Error("StaleVersionError should match ErrStaleVersion")
+	}
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	key := map[string]interface{}{"id": 1}
+	version, err := nyaMS.UpdateVersioned(context.Background(), "test", key, 1, map[string]interface{}{"name": "a"}, nyamysql.VersionOptions{Reload: true})
+	var stale *nyamysql.StaleVersionError
+	if errors.As(err, &stale) {
+		fmt.Println("stale, current row:", stale.Current)
+		version, err = nyaMS.UpdateVersioned(context.Background(), "test", key, stale.CurrentVersion, map[string]interface{}{"name": "a"}, nyamysql.VersionOptions{})
+	}
+	if err != nil {
+		fmt.Println("UpdateVersioned error:", err.Error())
+		return
+	}
+	fmt.Println("new version:", version)
+}
+
+func TestPaginate(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fq := nyamysql.PageQuery{Columns: "`id`,`name`", Table: "users", OrderBy: []nyamysql.PageOrder{{Column: "id"}}, Size: 1}
+	fake.ExpectQuery("^SELECT `id`,`name` FROM `users` ORDER BY `id` ASC LIMIT 2$").WillReturnRows([]string{"id", "name"}, []interface{}{1, "nya"}, []interface{}{2, "neko"})
+	first, err := fake.Paginate(context.Background(), fq, "")
+	if err != nil || first.Next == "" {
+		t.Fatalf("Paginate = %+v, %v", first, err)
+	}
+	// 令牌不能用於其他查詢
+	other := fq
+	other.Where = "`name`<>''"
+	if _, err := fake.Paginate(context.Background(), other, first.Next); !errors.Is(err, nyamysql.ErrInvalidPageToken) {
+		t.Errorf("token of another query: expected ErrInvalidPageToken, got %v", err)
+	}
+	// Columns 沒有包含排序列時，即使結果只有一頁也應在查詢前返回錯誤
+	missing := fq
+	missing.Columns = "`name`"
+	missing.Size = 100
+	if _, err := fake.Paginate(context.Background(), missing, ""); err == nil || errors.Is(err, nyamysql.ErrInvalidPageToken) {
+		t.Errorf("expected an OrderBy column error, got %v", err)
+	}
+	aliased := fq
+	aliased.Columns = "u.`id` AS `id`, CONCAT(`first`, ' ', `last`) name"
+	fake.ExpectQuery("^SELECT u.`id` AS `id`").WillReturnRows([]string{"id", "name"}, []interface{}{1, "nya"})
+	if _, err := fake.Paginate(context.Background(), aliased, ""); err != nil {
+		t.Errorf("aliased OrderBy column: %v", err)
+	}
+	// WithTrashed 的令牌不能用於預設（排除已刪除的行）的查詢
+	fake.SetSoftDelete("users", "deleted_at")
+	fake.ExpectQuery("^SELECT `id`,`name` FROM `users` ORDER BY").WillReturnRows([]string{"id", "name"}, []interface{}{1, "nya"}, []interface{}{2, "neko"})
+	trashed, err := fake.WithTrashed().Paginate(context.Background(), fq, "")
+	if err != nil || trashed.Next == "" {
+		t.Fatalf("Paginate WithTrashed = %+v, %v", trashed, err)
+	}
+	if _, err := fake.Paginate(context.Background(), fq, trashed.Next); !errors.Is(err, nyamysql.ErrInvalidPageToken) {
+		t.Errorf("WithTrashed token on the default view: expected ErrInvalidPageToken, got %v", err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	q := nyamysql.PageQuery{
+		Table:   "test",
+		OrderBy: []nyamysql.PageOrder{{Column: "created_at", Desc: true}, {Column: "id"}},
+		Size:    10,
+		Count:   true,
+	}
+	if _, err := nyaMS.Paginate(context.Background(), q, "not-a-token"); !errors.Is(err, nyamysql.ErrInvalidPageToken) {
+		t.Errorf("expected ErrInvalidPageToken, got %v", err)
+	}
+	page, err := nyaMS.Paginate(context.Background(), q, "")
+	if err != nil {
+		fmt.Println("Paginate error:", err.Error())
+		return
+	}
+	fmt.Println("total:", page.Total, "rows:", len(page.Rows))
+	if page.Next != "" {
+		next, err := nyaMS.Paginate(context.Background(), q, page.Next)
+		if err != nil {
+			fmt.Println("Paginate error:", err.Error())
+			return
+		}
+		prev, err := nyaMS.Paginate(context.Background(), q, next.Prev)
+		if err == nil && len(prev.Rows) > 0 && prev.Rows[0]["id"] != page.Rows[0]["id"] {
+			t.Error("Prev should return to the first page")
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	metrics := nyamysql.NewMetricsHook([]time.Duration{10 * time.Millisecond, time.Second})
+	var seen []interface{}
+	redacted := nyamysql.NewRedactHook(nyamysql.HookFuncs{AfterFunc: func(ctx context.Context, e *nyamysql.QueryEvent) {
+		seen = e.Args
+	}}, 0, nil)
+	e := &nyamysql.QueryEvent{Tag: "QueryData", SQL: "SELECT * FROM `test` WHERE `pwd`=?", Args: []interface{}{"secret", nil}, Duration: 20 * time.Millisecond}
+	for _, h := range []nyamysql.Hook{metrics, redacted} {
+		ctx := h.Before(context.Background(), e)
+		h.After(ctx, e)
+	}
+	if m := metrics.Snapshot()["QueryData"]; m.Count != 1 || m.Buckets[0] != 0 || m.Buckets[1] != 1 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+	if len(seen) != 2 || seen[0] != nyamysql.RedactedArg || seen[1] != nil || e.Args[0] != "secret" {
+		t.Errorf("unexpected redacted args: %v (original %v)", seen, e.Args)
+	}
+
+	// SqlExec 同樣經過鉤子
+	fake := nyamysqltest.NewFake()
+	fake.AddHook(metrics)
+	fake.ExpectExec("^TRUNCATE TABLE `test`$").WillReturnResult(0, 0)
+	if id := fake.SqlExec("TRUNCATE TABLE `test`"); id != 0 || fake.Error() != nil {
+		t.Errorf("SqlExec = %d, %v", id, fake.Error())
+	}
+	if m := metrics.Snapshot()["SqlExec"]; m.Count != 1 {
+		t.Errorf("SqlExec should run the hooks: %+v", m)
+	}
+	fake.Close()
+	if id := fake.SqlExec("TRUNCATE TABLE `test`"); id != -1 || !errors.Is(fake.Error(), nyamysql.ErrNotConnected) {
+		t.Errorf("SqlExec after Close = %d, %v", id, fake.Error())
+	}
+	var closed *nyamysql.NyaMySQL
+	if id := closed.SqlExec("SELECT 1"); id != -1 {
+		t.Errorf("SqlExec on nil = %d", id)
+	}
+
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	nyaMS.AddHook(metrics)
+	nyaMS.AddHook(nyamysql.NewSlowQueryHook(100*time.Millisecond, nil))
+	if _, err := nyaMS.QueryData("*", "test", "", "", ""); err != nil {
+		fmt.Println("QueryData error:", err.Error())
+	}
+	fmt.Printf("%+v\n", metrics.Snapshot())
+}
+
+func TestStmtCache(t *testing.T) {
+	// 交易中重複執行的語句只綁定一次，交易結束後釋放
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.ExpectQuery("^SELECT \\* FROM `test` WHERE `id`=\\?$").WithArgs(nyamysqltest.AnyArg).Times(3)
+	err := fake.WithTx(func(tx *nyamysql.Tx) error {
+		for i := 0; i < 3; i++ {
+			if _, err := tx.QueryTable("SELECT * FROM `test` WHERE `id`=?", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := fake.StmtCacheStats(); stats.Misses != 1 || stats.Hits != 2 || stats.Size != 1 {
+		t.Errorf("unexpected stmt cache stats in a transaction: %+v", stats)
+	}
+	tx, err := fake.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if _, err := tx.QueryTable("SELECT * FROM `test` WHERE `id`=?", 1); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("query after Rollback = %v", err)
+	}
+	fake.SetStmtCacheSize(0)
+	if stats := fake.StmtCacheStats(); stats.Size != 0 {
+		t.Errorf("cache should be empty after disabling: %+v", stats)
+	}
+
+	nyaMS := nyamysql.New(mysqlconfig, nil, 3)
+	if nyaMS.Error() != nil {
+		fmt.Println("MySQL DB Link error:", nyaMS.Error().Error())
+		return
+	}
+	defer nyaMS.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := nyaMS.QueryTable("SELECT * FROM `test` WHERE `id`=?", i); err != nil {
+			fmt.Println("QueryTable error:", err.Error())
+			return
+		}
+	}
+	if stats := nyaMS.StmtCacheStats(); stats.Misses != 1 || stats.Hits != 2 || stats.Size != 1 {
+		t.Errorf("unexpected stmt cache stats: %+v", stats)
+	}
+	nyaMS.SetStmtCacheSize(0)
+	if stats := nyaMS.StmtCacheStats(); stats.Size != 0 || stats.Capacity != 0 {
+		t.Errorf("cache should be empty after disabling: %+v", stats)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.SetSoftDelete("users", "deleted_at")
+	fake.ExpectQuery("^select \\* from `users` where \\(`id`>\\?\\) and `users`.`deleted_at` is null limit 1000$").WithArgs(1)
+	fake.ExpectQuery("^select \\* from `users` where `users`.`deleted_at` is not null limit 1000$")
+	fake.ExpectQuery("^select \\* from `users` limit 1000$")
+	fake.ExpectQuery("where \\(`u`.`deleted_at` is null\\) and `orders`.`deleted_at` is null limit 1000$")
+	// LEFT JOIN 的表在 ON 子句中過濾，只連接到已刪除訂單的使用者仍會保留
+	fake.ExpectQuery("^select \\* from `users` u LEFT JOIN `orders` o ON \\(o.`uid`=u.`id` OR o.`gid`=u.`gid`\\) and `o`.`deleted_at` is null JOIN `teams` t ON t.`id`=u.`tid` where `u`.`deleted_at` is null limit 1000$")
+	fake.ExpectQuery("^select \\* from `users` LEFT JOIN \\(select \\* from `orders` where `orders`.`deleted_at` is null\\) `orders` USING \\(`uid`\\) where `users`.`deleted_at` is null limit 1000$")
+	fake.ExpectExec("^update `users` set `deleted_at`=NOW\\(\\) where \\(`id`=\\? \\) and `deleted_at` is null$").WithArgs(1).WillReturnResult(0, 1)
+	fake.ExpectExec("^delete from `users` where `id`=\\? $").WithArgs(2).WillReturnResult(0, 1)
+	fake.ExpectExec("^update `users` set `deleted_at`=NULL where \\(`id` in \\(\\?,\\?\\) \\) and `deleted_at` is not null$").WithArgs(1, 2).WillReturnResult(0, 2)
+
+	fake.QueryData("*", "users", "`id`>?", "", "", 1)
+	fake.OnlyTrashed().QueryData("*", "users", "", "", "")
+	fake.WithTrashed().QueryData("*", "users", "", "", "")
+	fake.SetSoftDelete("orders", "deleted_at")
+	fake.QueryDataJOIN("*", []string{"`users` u", " JOIN `orders` ON `orders`.`uid`=u.`id`"}, "", "", "")
+	fake.QueryDataJOIN("*", []string{"`users` u", " LEFT JOIN `orders` o ON o.`uid`=u.`id` OR o.`gid`=u.`gid`", " JOIN `teams` t ON t.`id`=u.`tid`"}, "", "", "")
+	fake.QueryDataJOIN("*", []string{"`users`", " LEFT JOIN `orders` USING (`uid`)"}, "", "", "")
+	if n, err := fake.DeleteRecord("users", "id", "", 1); err != nil || n != 1 {
+		t.Errorf("soft DeleteRecord = %d, %v", n, err)
+	}
+	if n, err := fake.ForceDelete().DeleteRecord("users", "id", "", 2); err != nil || n != 1 {
+		t.Errorf("forced DeleteRecord = %d, %v", n, err)
+	}
+	if n, err := fake.Restore("users", "id", "", 1, 2); err != nil || n != 2 {
+		t.Errorf("Restore = %d, %v", n, err)
+	}
+	if _, err := fake.Restore("orders_archive", "id", "", 1); err == nil {
+		t.Error("Restore should fail on a table without soft delete")
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.EnableAudit(nyamysql.AuditOptions{PrimaryKeys: map[string][]string{"users": {"id"}}})
+	fake.ExpectQuery("^select \\* from `users` where `id`=\\? for update$").WithArgs(1).
+		WillReturnRows([]string{"id", "name", "note"}, []interface{}{1, "nya", nil})
+	fake.ExpectExec("^update `users` set `name`=\\? where `id`=\\?$").WithArgs("neko", 1).WillReturnResult(0, 1)
+	fake.ExpectQuery("^select \\* from `users` where \\(`id`=\\?\\)$").WithArgs("1").
+		WillReturnRows([]string{"id", "name", "note"}, []interface{}{1, "neko", nil})
+	fake.ExpectExec("^insert into `audit_log`").
+		WithArgs("users", "1", nyamysql.AuditUpdate, `{"id":"1","name":"nya","note":null}`, `{"id":"1","name":"neko","note":null}`, "admin").
+		WillReturnResult(1, 1)
+
+	ctx := nyamysql.WithAuditActor(context.Background(), "admin")
+	if n, err := fake.UpdateRecordContext(ctx, "users", "`name`=?", "`id`=?", "neko", 1); err != nil || n != 1 {
+		t.Errorf("UpdateRecord = %d, %v", n, err)
+	}
+	var sqls []string
+	for _, s := range fake.Statements() {
+		sqls = append(sqls, strings.Fields(s.SQL)[0])
+	}
+	if got := strings.Join(sqls, ","); got != "BEGIN,select,update,select,insert,COMMIT" {
+		t.Errorf("audit should run in one transaction, got %s", got)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	fake.Reset()
+	fake.ExpectQuery("for update$").WillReturnRows([]string{"id", "name"}, []interface{}{2, "nya"})
+	fake.ExpectExec("^delete from `users`").WillReturnResult(0, 1)
+	fake.ExpectQuery("^select \\* from `users` where \\(`id`=\\?\\)$").WithArgs("2")
+	fake.ExpectExec("^insert into `audit_log`").WithArgs("users", "2", nyamysql.AuditDelete, nyamysqltest.AnyArg, nil, "").
+		WillReturnError(nyamysqltest.MySQLError(1146, "Table 'audit_log' doesn't exist"))
+	if _, err := fake.DeleteRecord("users", "id", "", 2); err == nil {
+		t.Error("DeleteRecord should fail when the audit record cannot be written")
+	}
+	if stmts := fake.Statements(); stmts[len(stmts)-1].SQL != "ROLLBACK" {
+		t.Errorf("failed audit should roll back, got %q", stmts[len(stmts)-1].SQL)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// ON DUPLICATE KEY UPDATE 在唯一索引上衝突時按唯一索引讀取寫入前的影像
+	fake.Reset()
+	fake.ExpectQuery("information_schema.STATISTICS").WithArgs("users").
+		WillReturnRows([]string{"INDEX_NAME", "COLUMN_NAME"}, []interface{}{"uk_email", "email"}, []interface{}{"uk_lower", ""})
+	fake.ExpectQuery("^select \\* from `users` where \\(`email`=\\?\\) for update$").WithArgs("nya@example.com").
+		WillReturnRows([]string{"id", "email", "name"}, []interface{}{3, "nya@example.com", "nya"})
+	fake.ExpectExec("ON DUPLICATE KEY UPDATE").WillReturnResult(3, 2)
+	fake.ExpectQuery("^select \\* from `users` where \\(`email`=\\?\\)$").WithArgs("nya@example.com").
+		WillReturnRows([]string{"id", "email", "name"}, []interface{}{3, "nya@example.com", "neko"})
+	fake.ExpectExec("^insert into `audit_log`").
+		WithArgs("users", "3", nyamysql.AuditUpdate, `{"email":"nya@example.com","id":"3","name":"nya"}`, `{"email":"nya@example.com","id":"3","name":"neko"}`, "").
+		WillReturnResult(2, 1)
+	if _, _, err := fake.AddOrUpdateRecord("users", false, []string{"email", "name"}, []string{"name"}, "nya@example.com", "neko"); err != nil {
+		t.Errorf("AddOrUpdateRecord = %v", err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// `key` 不包含任何唯一索引時無法得知寫入前的影像，按 UPDATE 記錄
+	fake.Reset()
+	fake.ExpectExec("ON DUPLICATE KEY UPDATE").WillReturnResult(3, 2)
+	fake.ExpectExec("^insert into `audit_log`").
+		WithArgs("users", "", nyamysql.AuditUpdate, nil, `{"name":"neko"}`, "").
+		WillReturnResult(3, 1)
+	if _, _, err := fake.AddOrUpdateRecord("users", false, []string{"name"}, []string{"name"}, "neko"); err != nil {
+		t.Errorf("AddOrUpdateRecord = %v", err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDriverConfig(t *testing.T) {
+	conf := nyamysql.MySQLDBConfig{
+		User: "nya", Password: "pwd", Address: "db.example.com", Port: "3307", DbName: "test",
+		ParseTime: true, Loc: "Asia/Tokyo", Charset: "utf8mb4", Collation: "utf8mb4_unicode_ci",
+		Timeout: "5s", ReadTimeout: "30s", WriteTimeout: "30s", InterpolateParams: true,
+		TLS: nyamysql.MySQLTLSConfig{Mode: "skip-verify"},
+	}
+	cfg, err := conf.DriverConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Net != "tcp" || cfg.Addr != "db.example.com:3307" || cfg.User != "nya" || cfg.DBName != "test" {
+		t.Errorf("unexpected address: %+v", cfg)
+	}
+	if !cfg.ParseTime || cfg.Loc.String() != "Asia/Tokyo" || cfg.Collation != "utf8mb4_unicode_ci" || !cfg.InterpolateParams {
+		t.Errorf("unexpected options: %+v", cfg)
+	}
+	if cfg.Timeout != 5*time.Second || cfg.ReadTimeout != 30*time.Second || cfg.TLSConfig != "skip-verify" {
+		t.Errorf("unexpected timeouts or TLS: %+v", cfg)
+	}
+	if dsn := cfg.FormatDSN(); !strings.Contains(dsn, "charset=utf8mb4") {
+		t.Errorf("charset missing from %s", dsn)
+	}
+
+	conf = nyamysql.MySQLDBConfig{Socket: "/var/run/mysqld/mysqld.sock", Address: "ignored"}
+	if cfg, err := conf.DriverConfig(); err != nil || cfg.Net != "unix" || cfg.Addr != conf.Socket {
+		t.Errorf("socket config = %+v, %v", cfg, err)
+	}
+
+	var fromYAML nyamysql.MySQLDBConfig
+	if err := yaml.Unmarshal([]byte("mysql_socket: /tmp/mysql.sock\nmysql_tls:\n  mode: preferred\nmysql_parse_time: true\n"), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if fromYAML.Socket != "/tmp/mysql.sock" || fromYAML.TLS.Mode != "preferred" || !fromYAML.ParseTime {
+		t.Errorf("unexpected YAML config: %+v", fromYAML)
+	}
+
+	if cfg, err := (nyamysql.MySQLDBConfig{Port: "3306"}).DriverConfig(); err != nil || cfg.Net != "tcp" || cfg.Addr != "127.0.0.1:3306" {
+		t.Errorf("empty address should default to 127.0.0.1, got %+v, %v", cfg, err)
+	}
+
+	for _, bad := range []nyamysql.MySQLDBConfig{
+		{Address: "127.0.0.1", Port: "port"},
+		{Address: "127.0.0.1", Timeout: "5"},
+		{Address: "127.0.0.1", Loc: "Nowhere/Nya"},
+		{Address: "127.0.0.1", Charset: "utf8mb4;"},
+		{Address: "127.0.0.1", TLS: nyamysql.MySQLTLSConfig{Mode: "always"}},
+		{Address: "127.0.0.1", TLS: nyamysql.MySQLTLSConfig{Cert: "client.pem"}},
+		{Address: "127.0.0.1", TLS: nyamysql.MySQLTLSConfig{CA: "/nonexistent/ca.pem"}},
+		{Address: "127.0.0.1", TLS: nyamysql.MySQLTLSConfig{Mode: "skip-verify", CA: "ca.pem"}},
+		{Address: "127.0.0.1", TLS: nyamysql.MySQLTLSConfig{Mode: "preferred", CA: "ca.pem"}},
+	} {
+		if _, err := bad.DriverConfig(); err == nil {
+			t.Errorf("DriverConfig(%+v) should fail", bad)
+		}
+	}
+	if nyaMS := nyamysql.NewC(nyamysql.MySQLDBConfig{Address: "127.0.0.1", Timeout: "soon"}, nil, 0); nyaMS.Error() == nil {
+		t.Error("NewC should reject an invalid timeout")
+	}
+}
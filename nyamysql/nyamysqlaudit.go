@@ -0,0 +1,569 @@
+// MySQL 寫入審計
+package nyamysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultAuditTable 是預設的審計表名。
+const DefaultAuditTable = "audit_log"
+
+// 審計記錄的操作類型
+const (
+	AuditInsert = "INSERT"
+	AuditUpdate = "UPDATE"
+	AuditDelete = "DELETE"
+)
+
+// AuditOptions 是 EnableAudit 的配置。
+//
+//   - Table: 審計表，為空時使用 DefaultAuditTable 。可以用 CreateAuditTable 建立。
+//   - Tables: 需要審計的表，為空時審計所有表（審計表本身除外）。
+//   - PrimaryKeys: 表名 -> 主鍵欄位，沒有指定的表從 information_schema 讀取。
+//   - Actor: 從上下文取得操作者 ID ，為 nil 時使用 AuditActor 。
+type AuditOptions struct {
+	Table       string
+	Tables      []string
+	PrimaryKeys map[string][]string
+	Actor       func(ctx context.Context) string
+}
+
+// auditSet: 審計設定和主鍵快取，在 NyaMySQL 的副本之間共享
+type auditSet struct {
+	mu     sync.RWMutex
+	opts   *AuditOptions // 為 nil 時未啟用
+	tables map[string]bool
+	pks    map[string][]string
+	uks    map[string][][]string
+}
+
+// auditWrite: 一次需要審計的寫入
+type auditWrite struct {
+	table       string
+	where       string        // UPDATE 、DELETE 的條件，為空時表示整個表
+	whereValues []interface{} // 條件的值
+	key         []string      // INSERT 的欄位，不為 nil 時表示 INSERT
+	values      []interface{} // INSERT 的值
+	upsert      bool          // INSERT 帶有 ON DUPLICATE KEY UPDATE
+	delete      bool
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor 返回帶有操作者 ID 的上下文，審計記錄的 actor 欄位從中取得。
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActor 返回 WithAuditActor 設定的操作者 ID ，沒有設定時返回空字串。
+func AuditActor(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
+
+// EnableAudit 啟用寫入審計。
+//
+// 啟用後 AddRecord 、AddRecordLastInsertId 、AddOrUpdateRecord 、UpdateRecord 、
+// DeleteRecord 、DeleteRecordNoPK 寫入需要審計的表時，會在同一交易中（不在交易中時自動開始一個）
+// 讀取受影響的行在寫入前後的內容，並為每個有變化的行向審計表寫入一條記錄：
+// 表名、主鍵、操作、寫入前後的 JSON 影像和操作者 ID 。寫入或審計失敗時整個交易回滾。
+//
+// 主鍵為單一欄位時記錄其值，複合主鍵記錄為 JSON 陣列。沒有主鍵的表按行的順序對應前後影像，主鍵記錄為空。
+// UpdateRecord 的 `values` 中前 N 個值（N 為 `updata` 中 ? 的數量）被視為 `updata` 的值，其餘為條件的值。
+// 以多行 INSERT 寫入自增主鍵的表時，寫入後的影像為傳入的值（不含自增主鍵）。
+// AddOrUpdateRecord 的 `key` 不含主鍵時，按 `key` 包含的唯一索引讀取可能衝突的行作為寫入前的影像；
+// 表有唯一索引但 `key` 不包含任何一個的完整欄位時，無法得知寫入前的影像，
+// 可能被更新的行記錄為寫入前影像為 NULL 的 UPDATE 。
+//
+// 引數:
+//   - opts: 配置。
+func (p *NyaMySQL) EnableAudit(opts AuditOptions) {
+	if p.audit == nil {
+		return
+	}
+	if opts.Table == "" {
+		opts.Table = DefaultAuditTable
+	}
+	tables := make(map[string]bool, len(opts.Tables))
+	for _, t := range opts.Tables {
+		tables[t] = true
+	}
+	pks := make(map[string][]string, len(opts.PrimaryKeys))
+	for t, cols := range opts.PrimaryKeys {
+		pks[t] = cols
+	}
+	p.audit.mu.Lock()
+	defer p.audit.mu.Unlock()
+	p.audit.opts = &opts
+	p.audit.tables = tables
+	p.audit.pks = pks
+	p.audit.uks = map[string][][]string{}
+}
+
+// DisableAudit 停用寫入審計。
+func (p *NyaMySQL) DisableAudit() {
+	if p.audit == nil {
+		return
+	}
+	p.audit.mu.Lock()
+	defer p.audit.mu.Unlock()
+	p.audit.opts = nil
+}
+
+// CreateAuditTable 建立審計表（已存在時不做任何事），表名為 EnableAudit 配置的表或 DefaultAuditTable 。
+//
+// 引數:
+//   - ctx: 上下文。
+//
+// 返回值:
+//   - error: 錯誤。
+func (p *NyaMySQL) CreateAuditTable(ctx context.Context) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	table := DefaultAuditTable
+	if opts := p.auditOptions(); opts != nil {
+		table = opts.Table
+	}
+	dbq := "CREATE TABLE IF NOT EXISTS " + quoteIdent(table) + " (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`table_name` VARCHAR(64) NOT NULL," +
+		"`primary_key` VARCHAR(255) NOT NULL DEFAULT ''," +
+		"`operation` VARCHAR(8) NOT NULL," +
+		"`before_data` JSON NULL," +
+		"`after_data` JSON NULL," +
+		"`actor` VARCHAR(255) NOT NULL DEFAULT ''," +
+		"`created_at` DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+		"PRIMARY KEY (`id`)," +
+		"KEY `idx_table_pk` (`table_name`,`primary_key`)," +
+		"KEY `idx_created_at` (`created_at`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	_, err := p.execContext(ctx, "CreateAuditTable", dbq, nil)
+	return err
+}
+
+// auditOptions: 返回目前的審計配置，未啟用時返回 nil
+func (p *NyaMySQL) auditOptions() *AuditOptions {
+	if p.audit == nil {
+		return nil
+	}
+	p.audit.mu.RLock()
+	defer p.audit.mu.RUnlock()
+	return p.audit.opts
+}
+
+// auditFor: 返回表需要審計時的配置，不需要時返回 nil
+func (p *NyaMySQL) auditFor(table string) *AuditOptions {
+	if p.audit == nil {
+		return nil
+	}
+	p.audit.mu.RLock()
+	defer p.audit.mu.RUnlock()
+	opts := p.audit.opts
+	if opts == nil || table == opts.Table || (len(p.audit.tables) > 0 && !p.audit.tables[table]) {
+		return nil
+	}
+	return opts
+}
+
+// auditPrimaryKey: 返回表的主鍵欄位，結果會被快取
+func (p *NyaMySQL) auditPrimaryKey(ctx context.Context, table string) ([]string, error) {
+	p.audit.mu.RLock()
+	pk, ok := p.audit.pks[table]
+	p.audit.mu.RUnlock()
+	if ok {
+		return pk, nil
+	}
+	rows, err := p.queryContext(ctx, "AuditPrimaryKey", "SELECT `COLUMN_NAME` FROM information_schema.KEY_COLUMN_USAGE "+
+		"WHERE `TABLE_SCHEMA`=DATABASE() AND `TABLE_NAME`=? AND `CONSTRAINT_NAME`='PRIMARY' ORDER BY `ORDINAL_POSITION`", []interface{}{table})
+	if err != nil {
+		return nil, err
+	}
+	pk = []string{}
+	err = iterateRows(rows, func(r *Row) error {
+		var col string
+		if err := r.Scan(&col); err != nil {
+			return err
+		}
+		pk = append(pk, col)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.audit.mu.Lock()
+	p.audit.pks[table] = pk
+	p.audit.mu.Unlock()
+	return pk, nil
+}
+
+// auditExec: 執行寫入語句，表需要審計時在同一交易中寫入審計記錄
+func (p *NyaMySQL) auditExec(ctx context.Context, tag string, dbq string, values []interface{}, w *auditWrite) (sql.Result, error) {
+	opts := p.auditFor(w.table)
+	if opts == nil {
+		return p.execContext(ctx, tag, dbq, values)
+	}
+	if p.tx != nil {
+		return p.auditRun(ctx, opts, tag, dbq, values, w)
+	}
+	var result sql.Result
+	err := p.WithTxContext(ctx, func(tx *Tx) error {
+		var err error
+		result, err = tx.p.auditRun(ctx, opts, tag, dbq, values, w)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// auditRun: 在交易中讀取寫入前的影像、執行寫入、讀取寫入後的影像並寫入審計記錄
+func (p *NyaMySQL) auditRun(ctx context.Context, opts *AuditOptions, tag string, dbq string, values []interface{}, w *auditWrite) (sql.Result, error) {
+	pk, err := p.auditPrimaryKey(ctx, w.table)
+	if err != nil {
+		return nil, err
+	}
+	insertKeys := w.key != nil && len(pk) > 0 && containsAll(w.key, pk)
+	// upsertWhere: ON DUPLICATE KEY UPDATE 按唯一索引定位可能衝突的行的條件
+	var upsertWhere string
+	var upsertValues []interface{}
+	unknownBefore := false
+	if w.upsert && len(pk) > 0 && !insertKeys {
+		uks, err := p.auditUniqueKeys(ctx, w.table)
+		if err != nil {
+			return nil, err
+		}
+		upsertWhere, upsertValues = ukCondition(uks, w.key, insertRows(w.key, w.values))
+		unknownBefore = upsertWhere == "" && len(uks) > 0
+	}
+
+	var before []map[string]interface{}
+	switch {
+	case w.key == nil:
+		before, err = p.auditSnapshot(ctx, w.table, w.where, w.whereValues, true)
+	case insertKeys:
+		where, whereValues := pkCondition(pk, insertRows(w.key, w.values))
+		before, err = p.auditSnapshot(ctx, w.table, where, whereValues, true)
+	case upsertWhere != "":
+		before, err = p.auditSnapshot(ctx, w.table, upsertWhere, upsertValues, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.execContext(ctx, tag, dbq, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var after []map[string]interface{}
+	switch {
+	case len(pk) == 0 && w.key == nil:
+		after, err = p.auditSnapshot(ctx, w.table, w.where, w.whereValues, false)
+	case w.key == nil:
+		if len(before) > 0 {
+			where, whereValues := pkCondition(pk, before)
+			after, err = p.auditSnapshot(ctx, w.table, where, whereValues, false)
+		}
+	case insertKeys:
+		where, whereValues := pkCondition(pk, insertRows(w.key, w.values))
+		after, err = p.auditSnapshot(ctx, w.table, where, whereValues, false)
+	case upsertWhere != "":
+		// 寫入的行（新增或更新）仍然符合唯一索引的條件
+		after, err = p.auditSnapshot(ctx, w.table, upsertWhere, upsertValues, false)
+	default:
+		after = insertRows(w.key, w.values)
+		if len(pk) == 1 && len(after) == 1 {
+			affected, _ := result.RowsAffected()
+			id, _ := result.LastInsertId()
+			switch {
+			case unknownBefore && affected == 0:
+				// 衝突的行沒有變化
+				return result, nil
+			case unknownBefore && affected != 1:
+				// 更新了衝突的行，只記錄傳入的值
+			case id == 0:
+				// INSERT IGNORE 被忽略的行
+				return result, nil
+			default:
+				unknownBefore = false
+				after, err = p.auditSnapshot(ctx, w.table, quoteIdent(pk[0])+"=?", []interface{}{id}, false)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	actor := AuditActor(ctx)
+	if opts.Actor != nil {
+		actor = opts.Actor(ctx)
+	}
+	var rows []interface{}
+	for _, c := range pairImages(pk, before, after) {
+		if reflect.DeepEqual(c.before, c.after) {
+			continue
+		}
+		op := AuditUpdate
+		switch {
+		case c.before == nil && unknownBefore:
+			// 無法得知是新增還是更新，按可能被更新記錄
+		case c.before == nil:
+			op = AuditInsert
+		case c.after == nil || w.delete:
+			op = AuditDelete
+		}
+		beforeJSON, err := imageJSON(c.before)
+		if err != nil {
+			return nil, err
+		}
+		afterJSON, err := imageJSON(c.after)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, w.table, c.key, op, beforeJSON, afterJSON, actor)
+	}
+	if len(rows) == 0 {
+		return result, nil
+	}
+	key := []string{"table_name", "primary_key", "operation", "before_data", "after_data", "actor"}
+	if _, err := p.execContext(ctx, "Audit", insertSQL(opts.Table, false, key, []string{}, len(rows)/len(key)), rows); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// auditUniqueKeys: 返回表除主鍵外的唯一索引的欄位，含有表達式的索引除外，結果會被快取
+func (p *NyaMySQL) auditUniqueKeys(ctx context.Context, table string) ([][]string, error) {
+	p.audit.mu.RLock()
+	uks, ok := p.audit.uks[table]
+	p.audit.mu.RUnlock()
+	if ok {
+		return uks, nil
+	}
+	rows, err := p.queryContext(ctx, "AuditUniqueKeys", "SELECT `INDEX_NAME`,IFNULL(`COLUMN_NAME`,'') FROM information_schema.STATISTICS "+
+		"WHERE `TABLE_SCHEMA`=DATABASE() AND `TABLE_NAME`=? AND `NON_UNIQUE`=0 AND `INDEX_NAME`<>'PRIMARY' ORDER BY `INDEX_NAME`,`SEQ_IN_INDEX`", []interface{}{table})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	cols := map[string][]string{}
+	skip := map[string]bool{}
+	err = iterateRows(rows, func(r *Row) error {
+		var name, col string
+		if err := r.Scan(&name, &col); err != nil {
+			return err
+		}
+		if _, ok := cols[name]; !ok {
+			names = append(names, name)
+		}
+		cols[name] = append(cols[name], col)
+		if col == "" {
+			skip[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	uks = [][]string{}
+	for _, name := range names {
+		if !skip[name] {
+			uks = append(uks, cols[name])
+		}
+	}
+	p.audit.mu.Lock()
+	p.audit.uks[table] = uks
+	p.audit.mu.Unlock()
+	return uks, nil
+}
+
+// ukCondition: 生成按 `key` 包含的唯一索引定位多行的條件，沒有包含任何唯一索引時返回空字串
+func ukCondition(uks [][]string, key []string, images []map[string]interface{}) (string, []interface{}) {
+	var where []string
+	var values []interface{}
+	for _, uk := range uks {
+		if !containsAll(key, uk) {
+			continue
+		}
+		cond, condValues := pkCondition(uk, images)
+		where = append(where, cond)
+		values = append(values, condValues...)
+	}
+	return strings.Join(where, " OR "), values
+}
+
+// auditSnapshot: 讀取符合條件的行，NULL 值為 nil
+func (p *NyaMySQL) auditSnapshot(ctx context.Context, table string, where string, values []interface{}, forUpdate bool) ([]map[string]interface{}, error) {
+	dbq := "select * from " + quoteIdent(table)
+	if where != "" {
+		dbq += " where " + where
+	}
+	if forUpdate {
+		dbq += " for update"
+	}
+	rows, err := p.queryContext(ctx, "AuditSnapshot", dbq, values)
+	if err != nil {
+		return nil, err
+	}
+	var images []map[string]interface{}
+	err = iterateRows(rows, func(r *Row) error {
+		vals, err := r.Values()
+		if err != nil {
+			return err
+		}
+		image := make(map[string]interface{}, len(vals))
+		for i, v := range vals {
+			if v.Valid {
+				image[r.Columns()[i]] = v.String
+			} else {
+				image[r.Columns()[i]] = nil
+			}
+		}
+		images = append(images, image)
+		return nil
+	})
+	return images, err
+}
+
+// auditChange: 一行的前後影像
+type auditChange struct {
+	key    string
+	before map[string]interface{}
+	after  map[string]interface{}
+}
+
+// pairImages: 按主鍵對應前後影像，沒有主鍵時按順序對應
+func pairImages(pk []string, before []map[string]interface{}, after []map[string]interface{}) []auditChange {
+	var changes []auditChange
+	if len(pk) == 0 {
+		for i := 0; i < len(before) || i < len(after); i++ {
+			c := auditChange{}
+			if i < len(before) {
+				c.before = before[i]
+			}
+			if i < len(after) {
+				c.after = after[i]
+			}
+			changes = append(changes, c)
+		}
+		return changes
+	}
+	index := map[string]int{}
+	for _, image := range before {
+		key := imageKey(pk, image)
+		index[key] = len(changes)
+		changes = append(changes, auditChange{key: key, before: image})
+	}
+	for _, image := range after {
+		key := imageKey(pk, image)
+		if i, ok := index[key]; ok {
+			changes[i].after = image
+			continue
+		}
+		changes = append(changes, auditChange{key: key, after: image})
+	}
+	return changes
+}
+
+// imageKey: 返回影像的主鍵，單一欄位為其值，複合主鍵為 JSON 陣列
+func imageKey(pk []string, image map[string]interface{}) string {
+	if len(pk) == 1 {
+		return auditString(image[pk[0]])
+	}
+	vals := make([]string, len(pk))
+	for i, col := range pk {
+		vals[i] = auditString(image[col])
+	}
+	b, _ := json.Marshal(vals)
+	return string(b)
+}
+
+// auditString: 將傳入的值或讀取的值統一轉換為字串，使兩者可以比較
+func auditString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(string(b), "\"")
+}
+
+// imageJSON: 將影像轉換為 JSON ，nil 影像返回 nil（寫入 NULL）
+func imageJSON(image map[string]interface{}) (interface{}, error) {
+	if image == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(image)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// insertRows: 將 INSERT 的欄位和值轉換為影像
+func insertRows(key []string, values []interface{}) []map[string]interface{} {
+	var images []map[string]interface{}
+	for i := 0; i+len(key) <= len(values); i += len(key) {
+		image := make(map[string]interface{}, len(key))
+		for j, col := range key {
+			if b, ok := values[i+j].([]byte); ok {
+				image[col] = string(b)
+			} else {
+				image[col] = values[i+j]
+			}
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// pkCondition: 生成按主鍵定位多行的條件
+func pkCondition(pk []string, images []map[string]interface{}) (string, []interface{}) {
+	var where []string
+	var values []interface{}
+	for _, image := range images {
+		cond := make([]string, len(pk))
+		for i, col := range pk {
+			cond[i] = quoteIdent(col) + "=?"
+			values = append(values, image[col])
+		}
+		where = append(where, "("+strings.Join(cond, " AND ")+")")
+	}
+	return strings.Join(where, " OR "), values
+}
+
+// containsAll: 判斷 cols 是否包含 want 中的所有欄位
+func containsAll(cols []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, c := range cols {
+			if c == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,281 @@
+// MySQL 大量插入
+package nyamysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxPlaceholders 是 MySQL 單條預處理語句允許的最大佔位符數量。
+const MaxPlaceholders = 65535
+
+// ErrBulkSkipped 表示某個分塊因為之前的分塊失敗而沒有執行。
+var ErrBulkSkipped = errors.New("nyamysql: bulk chunk skipped after an earlier failure")
+
+// BulkOptions 是 BulkInsert 的配置。
+//
+//   - Ignore: 使用 INSERT IGNORE ，UpdateKeys 不為空時無效。
+//   - UpdateKeys: 不為空時使用 ON DUPLICATE KEY UPDATE 更新這些欄位，同 AddOrUpdateRecord 的 `upkey` 。
+//   - MaxRows: 每個分塊的最多行數，0 使用預設的 1000 。無論如何不會超過 MaxPlaceholders 的限制。
+//   - MaxBytes: 每個分塊語句的估計大小上限，0 使用預設的 4MB ，應小於伺服器的 max_allowed_packet 。
+//   - InTx: 在一個交易中依次執行所有分塊，任一分塊失敗時全部回滾。此時 Workers 無效。
+//   - Workers: 並行執行分塊的 goroutine 數量，小於等於 1 時依次執行。在交易中呼叫時無效。
+//   - ContinueOnError: 分塊失敗後繼續執行其餘分塊，InTx 時無效。
+//   - OnProgress: 每個分塊執行完成（無論成功與否）後呼叫，可以為 nil 。並行時不會被同時呼叫。
+type BulkOptions struct {
+	Ignore          bool
+	UpdateKeys      []string
+	MaxRows         int
+	MaxBytes        int
+	InTx            bool
+	Workers         int
+	ContinueOnError bool
+	OnProgress      func(progress BulkProgress)
+}
+
+// BulkProgress 是 BulkInsert 的進度。
+type BulkProgress struct {
+	ChunksDone  int
+	ChunksTotal int
+	RowsDone    int
+	RowsTotal   int
+	Failed      int
+	Elapsed     time.Duration
+}
+
+// BulkChunkResult 是一個分塊的執行結果。
+//
+//   - Index: 分塊序號，從 0 開始。
+//   - FirstRow: 分塊第一行在輸入中的行號，從 0 開始。
+//   - Rows: 分塊的行數。
+//   - RowsAffected: 受影響的行數（ON DUPLICATE KEY UPDATE 更新的行計為 2）。
+//   - LastInsertId: 分塊第一行的自增 ID 。
+//   - Err: 分塊的錯誤，沒有執行的分塊為 ErrBulkSkipped 。
+type BulkChunkResult struct {
+	Index        int
+	FirstRow     int
+	Rows         int
+	RowsAffected int64
+	LastInsertId int64
+	Err          error
+}
+
+// BulkResult 是 BulkInsert 的執行結果。
+//
+//   - Chunks: 按序號排列的每個分塊的結果。
+//   - RowsAffected: 所有成功分塊受影響行數的總和。
+//   - Failed: 失敗（包括沒有執行）的分塊數量。
+type BulkResult struct {
+	Chunks       []BulkChunkResult
+	RowsAffected int64
+	Failed       int
+}
+
+// bulkChunk: 一個分塊在 values 中的範圍
+type bulkChunk struct {
+	first int // 第一行的行號
+	rows  int
+}
+
+// BulkInsert 將大量資料分塊插入，避免單條語句超過 max_allowed_packet 或佔位符數量上限。
+//
+// 引數:
+//   - ctx: 上下文，被取消時停止執行尚未開始的分塊。
+//   - table: 表名，不需要反引號包裹。
+//   - key: 需要新增的欄位。
+//   - values: 按行依次排列的值，數量必須是 `key` 數量的整數倍，同 AddRecord 。
+//   - opts: 分塊和執行方式的配置。
+//
+// 返回值:
+//   - *BulkResult: 每個分塊的結果，引數錯誤時為 nil 。
+//   - error: 第一個失敗分塊的錯誤；ContinueOnError 時為 nil ，失敗情況見 BulkResult.Failed 。
+func (p *NyaMySQL) BulkInsert(ctx context.Context, table string, key []string, values []interface{}, opts BulkOptions) (*BulkResult, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if len(key) == 0 || len(values)%len(key) != 0 {
+		return nil, fmt.Errorf("'values'内容数量与'key'不符")
+	}
+	chunks := splitBulkChunks(table, key, opts, values)
+	if opts.InTx && p.tx == nil {
+		var result *BulkResult
+		err := p.WithTxContext(ctx, func(tx *Tx) error {
+			// 交易因死鎖重試時重新計算結果
+			var err error
+			result, err = tx.p.bulkRun(ctx, table, key, values, chunks, opts, false)
+			return err
+		})
+		return result, err
+	}
+	return p.bulkRun(ctx, table, key, values, chunks, opts, opts.ContinueOnError)
+}
+
+// BulkInsert: 同 NyaMySQL.BulkInsert ，在交易中依次執行， InTx 和 Workers 無效
+func (t *Tx) BulkInsert(ctx context.Context, table string, key []string, values []interface{}, opts BulkOptions) (*BulkResult, error) {
+	return t.p.BulkInsert(ctx, table, key, values, opts)
+}
+
+// bulkRun: 執行所有分塊。交易中或 Workers 小於等於 1 時依次執行，否則並行執行。
+func (p *NyaMySQL) bulkRun(ctx context.Context, table string, key []string, values []interface{}, chunks []bulkChunk, opts BulkOptions, continueOnError bool) (*BulkResult, error) {
+	start := time.Now()
+	width := len(key)
+	dbqs := map[int]string{} // 行數 -> 語句，除最後一個分塊外行數通常相同
+	var dbqMu sync.Mutex
+	statement := func(rows int) string {
+		dbqMu.Lock()
+		defer dbqMu.Unlock()
+		if dbq, ok := dbqs[rows]; ok {
+			return dbq
+		}
+		dbq := insertSQL(table, opts.Ignore, key, opts.UpdateKeys, rows)
+		dbqs[rows] = dbq
+		return dbq
+	}
+
+	result := &BulkResult{Chunks: make([]BulkChunkResult, len(chunks))}
+	progress := BulkProgress{ChunksTotal: len(chunks), RowsTotal: len(values) / width}
+	var mu sync.Mutex
+	var firstErr error
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	run := func(i int) {
+		c := chunks[i]
+		r := BulkChunkResult{Index: i, FirstRow: c.first, Rows: c.rows}
+		if runCtx.Err() != nil {
+			r.Err = ErrBulkSkipped
+			if ctx.Err() != nil {
+				r.Err = ctx.Err()
+			}
+		} else {
+			res, err := p.execContext(runCtx, "BulkInsert", statement(c.rows), values[c.first*width:(c.first+c.rows)*width])
+			if err == nil {
+				r.RowsAffected, _ = res.RowsAffected()
+				r.LastInsertId, _ = res.LastInsertId()
+			}
+			r.Err = err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		result.Chunks[i] = r
+		progress.ChunksDone++
+		if r.Err != nil {
+			result.Failed++
+			progress.Failed++
+			if firstErr == nil && r.Err != ErrBulkSkipped {
+				firstErr = fmt.Errorf("nyamysql: bulk chunk %d (rows %d-%d) failed: %w", i, c.first, c.first+c.rows-1, r.Err)
+			}
+			if !continueOnError {
+				cancel()
+			}
+		} else {
+			result.RowsAffected += r.RowsAffected
+			progress.RowsDone += c.rows
+		}
+		if opts.OnProgress != nil {
+			progress.Elapsed = time.Since(start)
+			opts.OnProgress(progress)
+		}
+	}
+
+	workers := opts.Workers
+	if p.tx != nil || workers < 1 {
+		workers = 1
+	}
+	if workers == 1 {
+		for i := range chunks {
+			run(i)
+		}
+	} else {
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					run(i)
+				}
+			}()
+		}
+		for i := range chunks {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
+
+	if continueOnError {
+		return result, nil
+	}
+	if firstErr == nil && ctx.Err() != nil && result.Failed > 0 {
+		firstErr = ctx.Err()
+	}
+	return result, firstErr
+}
+
+// splitBulkChunks: 按行數、語句大小和佔位符數量將 values 分塊
+func splitBulkChunks(table string, key []string, opts BulkOptions, values []interface{}) []bulkChunk {
+	width := len(key)
+	maxRows := opts.MaxRows
+	if maxRows <= 0 {
+		maxRows = 1000
+	}
+	if limit := MaxPlaceholders / width; maxRows > limit {
+		maxRows = limit
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 4 << 20
+	}
+	header := len(insertSQL(table, opts.Ignore, key, opts.UpdateKeys, 0))
+
+	chunks := []bulkChunk{}
+	total := len(values) / width
+	cur := bulkChunk{}
+	size := header
+	for row := 0; row < total; row++ {
+		rowSize := 3 // "(),"
+		for _, v := range values[row*width : (row+1)*width] {
+			rowSize += estimateValueSize(v) + 1
+		}
+		if cur.rows > 0 && (cur.rows >= maxRows || size+rowSize > maxBytes) {
+			chunks = append(chunks, cur)
+			cur = bulkChunk{first: row}
+			size = header
+		}
+		cur.rows++
+		size += rowSize
+	}
+	if cur.rows > 0 {
+		chunks = append(chunks, cur)
+	}
+	return chunks
+}
+
+// estimateValueSize: 估計一個值在傳送給伺服器時佔用的位元組數
+func estimateValueSize(v interface{}) int {
+	switch val := v.(type) {
+	case nil:
+		return 4
+	case string:
+		return len(val) + 2
+	case []byte:
+		return len(val) + 2
+	case *string:
+		if val == nil {
+			return 4
+		}
+		return len(*val) + 2
+	case time.Time:
+		return 28
+	case bool:
+		return 1
+	default:
+		return 20
+	}
+}
@@ -0,0 +1,177 @@
+// MySQL 連線配置
+package nyamysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLTLSConfig 結構體用於配置與 MySQL 伺服器之間的 TLS 連線。
+// 該結構體包含以下欄位：
+// - Mode: TLS 模式，可以為空或 "false"（不使用 TLS）、"true"（驗證伺服器憑證）、
+// "skip-verify"（不驗證伺服器憑證）、"preferred"（伺服器支援時使用 TLS ，不驗證憑證）。
+// 設定了 CA 或 Cert 而 Mode 為空時視為 "true" 。
+// - CA: 驗證伺服器憑證的 CA 憑證檔案（PEM），為空時使用系統的根憑證。只能用於 "true" 模式。
+// - Cert: 客戶端憑證檔案（PEM），必須與 Key 同時設定。
+// - Key: 客戶端私鑰檔案（PEM）。
+// - ServerName: 驗證伺服器憑證時使用的主機名，為空時使用 Address 。
+type MySQLTLSConfig struct {
+	Mode       string `json:"mode" yaml:"mode"`
+	CA         string `json:"ca" yaml:"ca"`
+	Cert       string `json:"cert" yaml:"cert"`
+	Key        string `json:"key" yaml:"key"`
+	ServerName string `json:"server_name" yaml:"server_name"`
+}
+
+// 字元集和排序規則名稱
+var charsetNameRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// DriverConfig 驗證配置並將其轉換為 go-sql-driver/mysql 的 *mysql.Config 。
+// NewC 和唯讀副本使用此方法建立連線，也可以用於 mysql.NewConnector 自行建立連線。
+//
+// 返回值:
+//   - *mysql.Config: 驅動配置。
+//   - error: 配置無效（例如埠號、逾時或時區無法解析、憑證檔案無法讀取）時返回錯誤。
+func (c MySQLDBConfig) DriverConfig() (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	if c.Charset != "" {
+		if !charsetNameRe.MatchString(c.Charset) {
+			return nil, fmt.Errorf("nyamysql: invalid mysql_charset %q", c.Charset)
+		}
+		// 驅動沒有公開字元集欄位，只能透過 DSN 參數設定
+		parsed, err := mysql.ParseDSN("/?charset=" + url.QueryEscape(c.Charset))
+		if err != nil {
+			return nil, fmt.Errorf("nyamysql: invalid mysql_charset %q: %w", c.Charset, err)
+		}
+		cfg = parsed
+	}
+	cfg.User = c.User
+	cfg.Passwd = c.Password
+	cfg.DBName = c.DbName
+
+	if c.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = c.Socket
+	} else {
+		address := c.Address
+		if address == "" {
+			address = "127.0.0.1"
+		}
+		cfg.Net = "tcp"
+		cfg.Addr = address
+		if c.Port != "" {
+			if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("nyamysql: invalid mysql_port %q", c.Port)
+			}
+			cfg.Addr = net.JoinHostPort(address, c.Port)
+		}
+	}
+
+	if c.Collation != "" {
+		if !charsetNameRe.MatchString(c.Collation) {
+			return nil, fmt.Errorf("nyamysql: invalid mysql_collation %q", c.Collation)
+		}
+		cfg.Collation = c.Collation
+	}
+	if c.Loc != "" {
+		loc, err := time.LoadLocation(c.Loc)
+		if err != nil {
+			return nil, fmt.Errorf("nyamysql: invalid mysql_loc %q: %w", c.Loc, err)
+		}
+		cfg.Loc = loc
+	}
+	var err error
+	if cfg.Timeout, err = parseTimeout("mysql_timeout", c.Timeout); err != nil {
+		return nil, err
+	}
+	if cfg.ReadTimeout, err = parseTimeout("mysql_read_timeout", c.ReadTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.WriteTimeout, err = parseTimeout("mysql_write_timeout", c.WriteTimeout); err != nil {
+		return nil, err
+	}
+	cfg.ParseTime = c.ParseTime
+	cfg.InterpolateParams = c.InterpolateParams
+
+	if err := c.TLS.apply(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseTimeout: 解析逾時設定（例如 "5s"），為空時返回 0
+func parseTimeout(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("nyamysql: invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+// apply: 將 TLS 配置套用到驅動配置，只有模式時使用驅動內建的設定，有憑證時建立 *tls.Config
+func (t MySQLTLSConfig) apply(cfg *mysql.Config) error {
+	mode := t.Mode
+	if mode == "" && (t.CA != "" || t.Cert != "") {
+		mode = "true"
+	}
+	switch mode {
+	case "", "false":
+		if t.CA != "" || t.Cert != "" {
+			return errors.New("nyamysql: mysql_tls certificates require TLS to be enabled")
+		}
+		return nil
+	case "true", "skip-verify", "preferred":
+	default:
+		return fmt.Errorf("nyamysql: invalid mysql_tls mode %q", t.Mode)
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("nyamysql: mysql_tls cert and key must be set together")
+	}
+	if t.CA != "" && mode != "true" {
+		// 不驗證伺服器憑證時 CA 不會被使用
+		return fmt.Errorf("nyamysql: mysql_tls ca requires mode \"true\", got %q", mode)
+	}
+	if t.CA == "" && t.Cert == "" && t.ServerName == "" {
+		cfg.TLSConfig = mode
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: mode != "true",
+	}
+	if t.CA != "" {
+		pem, err := os.ReadFile(t.CA)
+		if err != nil {
+			return fmt.Errorf("nyamysql: read mysql_tls ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("nyamysql: no certificate found in mysql_tls ca %q", t.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return fmt.Errorf("nyamysql: load mysql_tls cert: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	cfg.TLS = tlsConfig
+	cfg.AllowFallbackToPlaintext = mode == "preferred"
+	return nil
+}
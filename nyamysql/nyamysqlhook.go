@@ -0,0 +1,294 @@
+// MySQL 查詢鉤子
+package nyamysql
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueryEvent 描述一次語句執行，在 Hook.Before 和 Hook.After 中傳遞同一個物件。
+//
+//   - Tag: 發起執行的方法，例如 QueryData 、AddRecord ，同除錯日誌中的標記。
+//   - SQL: 執行的語句。
+//   - Args: 語句的引數，鉤子不應修改。
+//   - Exec: 是否為不返回結果集的語句（INSERT 、UPDATE 等）。
+//   - InTx: 是否在交易中執行。
+//   - Start: 開始執行的時間。
+//   - Duration: 執行耗時，只在 After 中有效。查詢只計算到取得結果集為止，不包括讀取各行的時間。
+//   - RowsAffected: 受影響的行數，只在 After 中對 Exec 語句有效，其他情況為 -1 。
+//   - Err: 執行的錯誤，只在 After 中有效。
+type QueryEvent struct {
+	Tag          string
+	SQL          string
+	Args         []interface{}
+	Exec         bool
+	InTx         bool
+	Start        time.Time
+	Duration     time.Duration
+	RowsAffected int64
+	Err          error
+}
+
+// Hook 在每條語句執行前後被呼叫，用於追蹤、慢查詢日誌和統計等。
+// 重試時每次嘗試都會分別呼叫。鉤子會在多個 goroutine 中同時被呼叫，實現需要並行安全。
+// 遷移的語句（Tag 為 Migrate 、MigrateUp 、MigrateDown）同樣會呼叫鉤子。
+// 例外：交易的 BEGIN 、COMMIT 、ROLLBACK 和 Ping 由驅動直接處理，不會呼叫鉤子。
+type Hook interface {
+	// Before 在語句執行前呼叫，返回的 context 會用於執行語句和呼叫 After ，不需要時原樣返回 ctx 。
+	Before(ctx context.Context, e *QueryEvent) context.Context
+	// After 在語句執行後呼叫。
+	After(ctx context.Context, e *QueryEvent)
+}
+
+// HookFuncs 以函式實現 Hook ，為 nil 的函式會被略過。
+type HookFuncs struct {
+	BeforeFunc func(ctx context.Context, e *QueryEvent) context.Context
+	AfterFunc  func(ctx context.Context, e *QueryEvent)
+}
+
+func (h HookFuncs) Before(ctx context.Context, e *QueryEvent) context.Context {
+	if h.BeforeFunc == nil {
+		return ctx
+	}
+	return h.BeforeFunc(ctx, e)
+}
+
+func (h HookFuncs) After(ctx context.Context, e *QueryEvent) {
+	if h.AfterFunc != nil {
+		h.AfterFunc(ctx, e)
+	}
+}
+
+// hookSet: 已註冊的鉤子，在 NyaMySQL 的副本（交易、ForcePrimary）之間共享
+type hookSet struct {
+	mu    sync.RWMutex
+	hooks []Hook
+}
+
+// AddHook 註冊一個鉤子，之後由此實例（包括其交易）執行的語句都會呼叫它。鉤子按註冊順序呼叫。
+func (p *NyaMySQL) AddHook(h Hook) {
+	if p.hooks == nil || h == nil {
+		return
+	}
+	p.hooks.mu.Lock()
+	p.hooks.hooks = append(p.hooks.hooks, h)
+	p.hooks.mu.Unlock()
+}
+
+// list: 返回目前的鉤子
+func (hs *hookSet) list() []Hook {
+	if hs == nil {
+		return nil
+	}
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.hooks
+}
+
+// beforeHooks: 呼叫所有鉤子的 Before ，沒有鉤子時返回 nil 事件
+func (p *NyaMySQL) beforeHooks(ctx context.Context, tag string, dbq string, values []interface{}, exec bool) (context.Context, *QueryEvent, []Hook) {
+	hooks := p.hooks.list()
+	if len(hooks) == 0 {
+		return ctx, nil, nil
+	}
+	e := &QueryEvent{Tag: tag, SQL: dbq, Args: values, Exec: exec, InTx: p.tx != nil, Start: time.Now(), RowsAffected: -1}
+	for _, h := range hooks {
+		ctx = h.Before(ctx, e)
+	}
+	return ctx, e, hooks
+}
+
+// afterHooks: 記錄結果並按相反順序呼叫所有鉤子的 After
+func afterHooks(ctx context.Context, e *QueryEvent, hooks []Hook, rowsAffected int64, err error) {
+	if e == nil {
+		return
+	}
+	e.Duration = time.Since(e.Start)
+	e.RowsAffected = rowsAffected
+	e.Err = err
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].After(ctx, e)
+	}
+}
+
+// SlowQueryHook 記錄耗時超過 Threshold 的語句。
+//
+//   - Threshold: 耗時閾值。
+//   - Logger: 日誌記錄器，為 nil 時使用 log 套件的預設記錄器。
+//   - WithArgs: 是否同時輸出引數。引數可能包含敏感資料，可以配合 NewRedactHook 使用。
+type SlowQueryHook struct {
+	Threshold time.Duration
+	Logger    *log.Logger
+	WithArgs  bool
+}
+
+// NewSlowQueryHook 建立一個慢查詢日誌鉤子。
+func NewSlowQueryHook(threshold time.Duration, logger *log.Logger) *SlowQueryHook {
+	return &SlowQueryHook{Threshold: threshold, Logger: logger}
+}
+
+func (h *SlowQueryHook) Before(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *SlowQueryHook) After(ctx context.Context, e *QueryEvent) {
+	if e.Duration < h.Threshold {
+		return
+	}
+	logf := log.Printf
+	if h.Logger != nil {
+		logf = h.Logger.Printf
+	}
+	query := e.SQL
+	if h.WithArgs {
+		query = dbPrintStr(e.SQL, e.Args)
+	}
+	if e.Err != nil {
+		logf("[%s]slow query %v: %s, error:[%v]", e.Tag, e.Duration, query, e.Err)
+	} else {
+		logf("[%s]slow query %v: %s", e.Tag, e.Duration, query)
+	}
+}
+
+// DefaultMetricsBuckets 是 MetricsHook 預設的耗時分佈區間上限。
+var DefaultMetricsBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// QueryMetrics 是一個 Tag 的統計，欄位含義與 Prometheus 的 counter 和 histogram 對應。
+//
+//   - Count: 執行次數。
+//   - Errors: 出錯次數。
+//   - Total: 累計耗時。
+//   - Buckets: 耗時小於等於 Bounds 中對應上限的累計次數。
+//   - Bounds: 耗時區間上限，與 Buckets 一一對應。
+type QueryMetrics struct {
+	Count   int64
+	Errors  int64
+	Total   time.Duration
+	Buckets []int64
+	Bounds  []time.Duration
+}
+
+// MetricsHook 按 Tag 統計語句的執行次數、錯誤次數和耗時分佈，可以定期讀取 Snapshot 匯出到監控系統。
+type MetricsHook struct {
+	mu      sync.Mutex
+	bounds  []time.Duration
+	metrics map[string]*QueryMetrics
+}
+
+// NewMetricsHook 建立一個統計鉤子， `buckets` 為耗時區間上限（遞增），為 nil 時使用 DefaultMetricsBuckets 。
+func NewMetricsHook(buckets []time.Duration) *MetricsHook {
+	if buckets == nil {
+		buckets = DefaultMetricsBuckets
+	}
+	bounds := append([]time.Duration{}, buckets...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &MetricsHook{bounds: bounds, metrics: map[string]*QueryMetrics{}}
+}
+
+func (h *MetricsHook) Before(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *MetricsHook) After(ctx context.Context, e *QueryEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.metrics[e.Tag]
+	if !ok {
+		m = &QueryMetrics{Buckets: make([]int64, len(h.bounds)), Bounds: h.bounds}
+		h.metrics[e.Tag] = m
+	}
+	m.Count++
+	if e.Err != nil {
+		m.Errors++
+	}
+	m.Total += e.Duration
+	for i, bound := range h.bounds {
+		if e.Duration <= bound {
+			m.Buckets[i]++
+		}
+	}
+}
+
+// Snapshot 返回目前各 Tag 的統計副本。
+func (h *MetricsHook) Snapshot() map[string]QueryMetrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshot := make(map[string]QueryMetrics, len(h.metrics))
+	for tag, m := range h.metrics {
+		c := *m
+		c.Buckets = append([]int64{}, m.Buckets...)
+		snapshot[tag] = c
+	}
+	return snapshot
+}
+
+// Reset 清空所有統計。
+func (h *MetricsHook) Reset() {
+	h.mu.Lock()
+	h.metrics = map[string]*QueryMetrics{}
+	h.mu.Unlock()
+}
+
+// RedactedArg 是 NewRedactHook 替換引數時預設使用的值。
+const RedactedArg = "[REDACTED]"
+
+// redactKey: 在 context 中記錄本次事件是否保留引數
+type redactKey struct{}
+
+// redactHook: 隱藏引數後再交給內層鉤子
+type redactHook struct {
+	inner      Hook
+	sampleRate float64
+	redact     func(arg interface{}) interface{}
+}
+
+// NewRedactHook 包裝一個鉤子，使其只在抽樣到的事件中看到真實引數，其餘事件的引數被替換。
+//
+// 引數:
+//   - inner: 被包裝的鉤子。
+//   - sampleRate: 保留真實引數的事件比例（0 到 1），0 表示總是隱藏。
+//   - redact: 替換單個引數的函式，為 nil 時替換為 RedactedArg （nil 引數保持為 nil）。
+//
+// 返回值:
+//   - Hook: 包裝後的鉤子。
+func NewRedactHook(inner Hook, sampleRate float64, redact func(arg interface{}) interface{}) Hook {
+	if redact == nil {
+		redact = func(arg interface{}) interface{} {
+			if arg == nil {
+				return nil
+			}
+			return RedactedArg
+		}
+	}
+	return &redactHook{inner: inner, sampleRate: sampleRate, redact: redact}
+}
+
+func (h *redactHook) Before(ctx context.Context, e *QueryEvent) context.Context {
+	keep := h.sampleRate > 0 && rand.Float64() < h.sampleRate
+	ctx = context.WithValue(ctx, redactKey{}, keep)
+	return h.inner.Before(ctx, h.event(ctx, e))
+}
+
+func (h *redactHook) After(ctx context.Context, e *QueryEvent) {
+	h.inner.After(ctx, h.event(ctx, e))
+}
+
+// event: 按 Before 時的抽樣結果返回原事件或隱藏引數後的副本
+func (h *redactHook) event(ctx context.Context, e *QueryEvent) *QueryEvent {
+	if keep, _ := ctx.Value(redactKey{}).(bool); keep || len(e.Args) == 0 {
+		return e
+	}
+	c := *e
+	c.Args = make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		c.Args[i] = h.redact(arg)
+	}
+	return &c
+}
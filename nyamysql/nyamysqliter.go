@@ -0,0 +1,136 @@
+// MySQL 逐行遍歷查詢結果
+package nyamysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrStopIteration 由 Iterate 的回呼函式返回，表示提前結束遍歷。
+// Iterate 遇到此錯誤時會關閉結果集並返回 nil 。
+var ErrStopIteration = errors.New("nyamysql: stop iteration")
+
+// Row 是 Iterate 遍歷時的目前行，只在回呼函式執行期間有效，不應保留到回呼函式之外。
+type Row struct {
+	rows  *sql.Rows
+	cols  []string
+	index int64
+}
+
+// Columns 返回結果集的列名。
+func (r *Row) Columns() []string {
+	return r.cols
+}
+
+// Index 返回目前行在結果集中的序號，從 0 開始。
+func (r *Row) Index() int64 {
+	return r.index
+}
+
+// Scan 將目前行的各列依次掃描到 dest 中，用法同 sql.Rows.Scan 。
+func (r *Row) Scan(dest ...interface{}) error {
+	return r.rows.Scan(dest...)
+}
+
+// Map 以 列名 -> 值 的形式返回目前行，格式同 QueryData 結果中的一行。
+// NULL 值返回空字串，需要區分 NULL 時使用 Values 。
+func (r *Row) Map() (map[string]string, error) {
+	vals, err := r.Values()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(r.cols))
+	for i, v := range vals {
+		row[r.cols[i]] = v.String
+	}
+	return row, nil
+}
+
+// Values 按列的順序返回目前行的值，NULL 值的 Valid 為 false 。
+func (r *Row) Values() ([]sql.NullString, error) {
+	vals := make([]sql.NullString, len(r.cols))
+	scans := make([]interface{}, len(r.cols))
+	for i := range vals {
+		scans[i] = &vals[i]
+	}
+	if err := r.rows.Scan(scans...); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// Iterate 執行查詢並逐行呼叫 fn ，不會把整個結果集載入記憶體，適合匯出大表。
+//
+// fn 返回 ErrStopIteration 時提前結束並返回 nil ；返回其他錯誤時結束遍歷並返回該錯誤。
+// 無論以何種方式結束，結果集都會被關閉。
+//
+// 引數:
+//   - ctx: 上下文，被取消時遍歷中止並返回 ctx 的錯誤。
+//   - dbq: 完整的SQL查詢語句。
+//   - values: 查詢條件的值，沒有時填寫 nil 。
+//   - fn: 處理每一行的回呼函式。
+//
+// 返回值:
+//   - error: 查詢、掃描或 fn 返回的錯誤。
+func (p *NyaMySQL) Iterate(ctx context.Context, dbq string, values []interface{}, fn func(row *Row) error) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	rows, err := p.readContext(ctx, "Iterate", dbq, values)
+	if err != nil {
+		return err
+	}
+	return iterateRows(rows, fn)
+}
+
+// Iterate: 同 NyaMySQL.Iterate ，在交易中執行
+func (t *Tx) Iterate(ctx context.Context, dbq string, values []interface{}, fn func(row *Row) error) error {
+	return t.p.Iterate(ctx, dbq, values, fn)
+}
+
+// IterateInto 同 Iterate ，但把每一行掃描為 T 後再呼叫 fn 。對映規則同 QueryInto 。
+func IterateInto[T any](ctx context.Context, q Querier, dbq string, values []interface{}, fn func(v T) error) error {
+	rows, err := q.QueryRowsContext(ctx, dbq, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	scan, err := newRowScanner[T](rows)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// iterateRows: 逐行呼叫 fn 並在結束時關閉結果集
+func iterateRows(rows *sql.Rows, fn func(row *Row) error) error {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	row := &Row{rows: rows, cols: cols}
+	for rows.Next() {
+		if err := fn(row); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+		row.index++
+	}
+	return rows.Err()
+}
@@ -2,13 +2,14 @@
 package nyamysql
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"regexp"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,17 +17,50 @@ import (
 // 該結構體包含以下欄位：
 // - User: MySQL使用者名稱。
 // - Password: MySQL密碼。
-// - Address: MySQL伺服器地址。
-// - Port: MySQL伺服器埠。
+// - Address: MySQL伺服器地址，和 Socket 都為空時使用 127.0.0.1 。
+// - Port: MySQL伺服器埠，為空時使用 3306 。
+// - Socket: Unix Socket 路徑，設定後忽略 Address 和 Port 。
 // - DbName: 資料庫名稱。
 // - MaxLimit: 資料的最大限制。
+// - TLS: TLS 連線配置。
+// - ParseTime: 是否將 DATE 、DATETIME 等欄位解析為 time.Time 。
+// - Loc: ParseTime 時使用的時區，例如 "Local" 、"Asia/Tokyo" ，為空時使用 UTC 。
+// - Charset: 連線字元集，例如 "utf8mb4" 。
+// - Collation: 連線排序規則，例如 "utf8mb4_unicode_ci" 。
+// - Timeout: 建立連線的逾時，例如 "5s" 。
+// - ReadTimeout: 讀取的逾時。
+// - WriteTimeout: 寫入的逾時。
+// - InterpolateParams: 是否在客戶端替換佔位符，以減少預處理語句的往返。
+// - Replicas: 唯讀副本列表，查詢會輪流發送到健康的副本，寫入和交易使用主庫。
+//
+// 配置在 NewC 中由 DriverConfig 驗證並轉換為 mysql.Config 。
 type MySQLDBConfig struct {
+	User              string               `json:"mysql_user" yaml:"mysql_user"`
+	Password          string               `json:"mysql_pwd" yaml:"mysql_pwd"`
+	Address           string               `json:"mysql_addr" yaml:"mysql_addr"`
+	Port              string               `json:"mysql_port" yaml:"mysql_port"`
+	Socket            string               `json:"mysql_socket" yaml:"mysql_socket"`
+	DbName            string               `json:"mysql_db" yaml:"mysql_db"`
+	MaxLimit          string               `json:"mysql_limit" yaml:"mysql_limit"`
+	TLS               MySQLTLSConfig       `json:"mysql_tls" yaml:"mysql_tls"`
+	ParseTime         bool                 `json:"mysql_parse_time" yaml:"mysql_parse_time"`
+	Loc               string               `json:"mysql_loc" yaml:"mysql_loc"`
+	Charset           string               `json:"mysql_charset" yaml:"mysql_charset"`
+	Collation         string               `json:"mysql_collation" yaml:"mysql_collation"`
+	Timeout           string               `json:"mysql_timeout" yaml:"mysql_timeout"`
+	ReadTimeout       string               `json:"mysql_read_timeout" yaml:"mysql_read_timeout"`
+	WriteTimeout      string               `json:"mysql_write_timeout" yaml:"mysql_write_timeout"`
+	InterpolateParams bool                 `json:"mysql_interpolate_params" yaml:"mysql_interpolate_params"`
+	Replicas          []MySQLReplicaConfig `json:"mysql_replicas" yaml:"mysql_replicas"`
+}
+
+// MySQLReplicaConfig 結構體用於配置一個唯讀副本。
+// User 和 Password 為空時使用主庫的設定，資料庫名稱和其他連線選項（TLS 、逾時等）總是與主庫相同。
+type MySQLReplicaConfig struct {
 	User     string `json:"mysql_user" yaml:"mysql_user"`
 	Password string `json:"mysql_pwd" yaml:"mysql_pwd"`
 	Address  string `json:"mysql_addr" yaml:"mysql_addr"`
 	Port     string `json:"mysql_port" yaml:"mysql_port"`
-	DbName   string `json:"mysql_db" yaml:"mysql_db"`
-	MaxLimit string `json:"mysql_limit" yaml:"mysql_limit"`
 }
 
 const (
@@ -44,27 +78,36 @@ type NyaMySQL NyaMySQLT
 // - limit: 資料庫連線的最大限制。
 // - err: 用於儲存資料庫操作中的錯誤資訊。
 // - debug: 用於除錯的日誌記錄器。
+// - tx: 交易中的副本所綁定的交易，為 nil 時直接使用 db。
+// - replicas: 唯讀副本，沒有配置時為 nil。
+// - forcePrimary: 為 true 時查詢也使用主庫。
+// - retry: 暫時性錯誤的重試策略。
+// - hooks: 查詢鉤子，在副本之間共享。
+// - stmts: 預處理語句快取，在副本之間共享。
+// - txStmts: 交易中已綁定到交易連線的預處理語句，在同一交易的副本之間共享。
+// - softDeletes: 軟刪除設定，在副本之間共享。
+// - trashed: 查詢時對已軟刪除行的處理方式。
+// - forceDelete: 為 true 時刪除不使用軟刪除。
+// - audit: 寫入審計設定，在副本之間共享。
 type NyaMySQLT struct {
-	db          *sql.DB
-	limit       string
-	err         error
-	loggerLevel int
-	debug       *log.Logger
-}
-
-type ParametersSave struct {
-	Save        bool
-	Config      MySQLDBConfig
-	loggerLevel int
-	Debug       *log.Logger
+	db           *sql.DB
+	tx           *sql.Tx
+	replicas     *replicaSet
+	forcePrimary bool
+	retry        RetryPolicy
+	hooks        *hookSet
+	stmts        *stmtCache
+	txStmts      *txStmtCache
+	softDeletes  *softDeleteSet
+	trashed      int
+	forceDelete  bool
+	audit        *auditSet
+	limit        string
+	err          error
+	loggerLevel  int
+	debug        *log.Logger
 }
 
-var (
-	parametersSave ParametersSave = ParametersSave{
-		Save: false,
-	}
-)
-
 // New 函式用於根據傳入的配置字串建立一個新的 NyaMySQL 例項。
 // 該函式嘗試解析配置字串為 JSON 或 YAML 格式，並根據解析結果初始化 MySQL 配置。
 // 如果解析成功，則呼叫 NewC 函式建立並返回 NyaMySQL 例項；如果解析失敗，則返回一個包含錯誤的 NyaMySQL 例項。
@@ -118,7 +161,15 @@ func (p *NyaMySQL) Stats() sql.DBStats {
 }
 
 func (p *NyaMySQL) Ping() error {
-	return p.db.Ping()
+	return p.PingContext(context.Background())
+}
+
+// PingContext 同 Ping ，可透過 ctx 設定逾時
+func (p *NyaMySQL) PingContext(ctx context.Context) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	return p.db.PingContext(ctx)
 }
 
 func (p *NyaMySQL) SetDebug(Debug *log.Logger) {
@@ -136,35 +187,69 @@ func (p *NyaMySQL) SetDebug(Debug *log.Logger) {
 // 返回值:
 //   - *NyaMySQL: 返回一個指向 NyaMySQL 結構體的指標，該結構體包含資料庫連線、最大連線限制和除錯日誌記錄器。
 func NewC(mySQLConfig MySQLDBConfig, Debug *log.Logger, logLevel int) *NyaMySQL {
-	parametersSave.Save = true
-	parametersSave.Config = mySQLConfig
-	parametersSave.loggerLevel = logLevel
-	parametersSave.Debug = Debug
-	// 根據 MySQL 配置資訊生成連線字串
-	var sqlsetting string = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", mySQLConfig.User, mySQLConfig.Password, mySQLConfig.Address, mySQLConfig.Port, mySQLConfig.DbName)
-
-	// 使用生成的連線字串開啟資料庫連線
-	sqldb, err := sql.Open("mysql", sqlsetting)
+	return newContext(context.Background(), mySQLConfig, Debug, logLevel)
+}
+
+// newContext: 同 NewC ，連線和 ping 可透過 ctx 取消
+func newContext(ctx context.Context, mySQLConfig MySQLDBConfig, Debug *log.Logger, logLevel int) *NyaMySQL {
+	// 驗證配置並開啟資料庫連線
+	sqldb, err := openDB(mySQLConfig)
 	if err != nil {
-		// 如果連線失敗，返回包含錯誤資訊的 NyaMySQL 物件
+		// 如果配置無效，返回包含錯誤資訊的 NyaMySQL 物件
 		return &NyaMySQL{err: err}
 	}
 
 	// 嘗試 ping 資料庫以驗證連線是否成功
-	if err := sqldb.Ping(); err != nil {
-		// 如果 ping 失敗，返回包含錯誤資訊的 NyaMySQL 物件
+	if err := sqldb.PingContext(ctx); err != nil {
+		// 如果 ping 失敗，關閉連線並返回包含錯誤資訊的 NyaMySQL 物件
+		sqldb.Close()
 		return &NyaMySQL{err: err}
 	}
 
-	// 返回成功初始化的 NyaMySQL 物件，包含資料庫連線、最大連線限制和除錯日誌記錄器
+	// 返回成功初始化的 NyaMySQL 物件，包含資料庫連線、唯讀副本、最大連線限制和除錯日誌記錄器
+	p := NewFromDB(sqldb, mySQLConfig.MaxLimit, Debug, logLevel)
+	p.replicas = openReplicas(mySQLConfig, Debug)
+	return p
+}
+
+// NewFromDB 以已開啟的 *sql.DB 建立 NyaMySQL 實例，用於自訂驅動或連線方式（例如測試替身）。
+// 不會 Ping 資料庫，也不支援唯讀副本。
+//
+// 引數:
+//   - db: 已開啟的資料庫連線，實例關閉時會一併關閉。
+//   - maxLimit: QueryData 等方法沒有指定 limit 時使用的預設值，同 MySQLDBConfig.MaxLimit 。
+//   - Debug: 用於記錄除錯資訊的日誌記錄器，可以為 nil 。
+//   - logLevel: 日誌級別。
+//
+// 返回值:
+//   - *NyaMySQL: 新的實例。
+func NewFromDB(db *sql.DB, maxLimit string, Debug *log.Logger, logLevel int) *NyaMySQL {
 	return &NyaMySQL{
-		db:          sqldb,
-		limit:       mySQLConfig.MaxLimit,
+		db:          db,
+		retry:       DefaultRetryPolicy(),
+		hooks:       &hookSet{},
+		stmts:       newStmtCache(DefaultStmtCacheSize),
+		softDeletes: &softDeleteSet{tables: map[string]string{}},
+		audit:       &auditSet{},
+		limit:       maxLimit,
 		loggerLevel: logLevel,
 		debug:       Debug,
 	}
 }
 
+// openDB 根據 MySQL 配置資訊開啟資料庫連線。
+func openDB(mySQLConfig MySQLDBConfig) (*sql.DB, error) {
+	cfg, err := mySQLConfig.DriverConfig()
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
 // SqlExec 執行給定的SQL命令，並返回受影響行的最後插入ID。
 // 如果執行過程中發生錯誤，返回-1，並將錯誤資訊儲存在p.err中。
 //
@@ -174,8 +259,27 @@ func NewC(mySQLConfig MySQLDBConfig, Debug *log.Logger, logLevel int) *NyaMySQL
 // 返回值:
 //   - int64: 成功執行時返回最後插入的ID，失敗時返回-1。
 func (p *NyaMySQL) SqlExec(sqlCmd string) int64 {
-	// 執行SQL命令
-	result, err := p.db.Exec(sqlCmd)
+	return p.SqlExecContext(context.Background(), sqlCmd)
+}
+
+// SqlExecContext 同 SqlExec ，可透過 ctx 取消執行或設定逾時。
+//
+// 引數:
+//   - ctx: 上下文。
+//   - sqlCmd: 要執行的SQL命令字串。
+//
+// 返回值:
+//   - int64: 成功執行時返回最後插入的ID，失敗時返回-1。
+func (p *NyaMySQL) SqlExecContext(ctx context.Context, sqlCmd string) int64 {
+	if err := p.check(); err != nil {
+		if p != nil {
+			p.err = err
+		}
+		return -1
+	}
+
+	// 執行SQL命令，同其他方法經過鉤子和重試策略
+	result, err := p.execContext(ctx, "SqlExec", sqlCmd, nil)
 	p.err = err
 
 	// 如果執行過程中發生錯誤，返回-1
@@ -188,6 +292,21 @@ func (p *NyaMySQL) SqlExec(sqlCmd string) int64 {
 	return id
 }
 
+// check 檢查實例是否可用。
+// 實例為 nil 或已經關閉時返回 ErrNotConnected ，建立時連線失敗則返回當時的錯誤。
+func (p *NyaMySQL) check() error {
+	if p == nil {
+		return ErrNotConnected
+	}
+	if p.db == nil {
+		if p.err != nil {
+			return p.err
+		}
+		return ErrNotConnected
+	}
+	return nil
+}
+
 // Error 返回 NyaMySQL 例項中儲存的上一次操作產生的錯誤。
 // 該函式通常用於檢查在執行資料庫操作時是否發生了錯誤。
 //
@@ -216,12 +335,10 @@ func (p *NyaMySQL) ErrorString() string {
 func (p *NyaMySQL) Close() {
 	// 檢查資料庫連線是否已初始化
 	if p.db != nil {
-		parametersSave.Save = false
-		parametersSave.Config = MySQLDBConfig{}
-		parametersSave.loggerLevel = NYAMYSQL_LOG_LEVEL_ERROR
-		parametersSave.Debug = nil
-		// 關閉資料庫連線
+		// 關閉快取的預處理語句、資料庫連線和唯讀副本
+		p.stmts.close()
 		p.db.Close()
+		p.replicas.close()
 		// 將資料庫連線指標置為 nil，防止重複關閉
 		p.db = nil
 	}
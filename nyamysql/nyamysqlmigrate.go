@@ -0,0 +1,525 @@
+// MySQL 資料庫結構遷移
+package nyamysql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMigrationTable 是記錄已執行遷移的預設表名。
+const DefaultMigrationTable = "schema_migrations"
+
+var (
+	// ErrChecksumMismatch 表示已執行的遷移檔案在執行後被修改過。
+	ErrChecksumMismatch = errors.New("nyamysql: migration checksum mismatch")
+	// ErrMigrationLocked 表示在等待時間內無法取得遷移鎖，通常是其他實例正在遷移。
+	ErrMigrationLocked = errors.New("nyamysql: migration lock is held by another session")
+)
+
+// Migration 是一個版本的遷移腳本。
+//
+//   - Version: 版本號，來自檔名開頭的數字。
+//   - Name: 版本名稱，來自檔名中版本號之後的部分。
+//   - Up: 升級腳本。
+//   - Down: 降級腳本，沒有提供時為空。
+//   - Checksum: 升級和降級腳本的 SHA-256 ，用於檢測已執行的遷移是否被修改。沒有降級腳本時只計算升級腳本。
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus 是一個遷移的執行狀態。
+// 資料庫中有記錄但遷移來源中找不到的版本，Migration 只有 Version 、Name 和 Checksum 。
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// ChecksumMismatchError 描述了被修改過的遷移，可以用 errors.Is(err, ErrChecksumMismatch) 判斷。
+type ChecksumMismatchError struct {
+	Version  int64
+	Name     string
+	Expected string // 執行時記錄的校驗值
+	Actual   string // 目前檔案的校驗值
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("nyamysql: migration %d_%s was modified after being applied (checksum %s, now %s)", e.Version, e.Name, e.Expected, e.Actual)
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// MigrateOptions 是遷移的配置。
+//
+//   - Table: 記錄已執行遷移的表名，預設為 DefaultMigrationTable 。
+//   - LockName: GET_LOCK 使用的鎖名稱，預設為 "nyamysql:<資料庫名>.<Table>" 。
+//   - LockTimeout: 等待遷移鎖的時間，預設為 30 秒。
+//   - DryRun: 不為 nil 時只把將要執行的語句寫入其中，不修改資料庫，也不取得鎖。
+type MigrateOptions struct {
+	Table       string
+	LockName    string
+	LockTimeout time.Duration
+	DryRun      io.Writer
+}
+
+// Migrator 按版本順序執行遷移腳本，並在資料庫中記錄已執行的版本。
+type Migrator struct {
+	p          *NyaMySQL
+	migrations []Migration
+	opts       MigrateOptions
+}
+
+// LoadMigrations 從 fsys 的 dir 目錄讀取遷移腳本，可以使用 embed.FS 或 os.DirFS 。
+//
+// 檔名格式為 `<版本號>_<名稱>.up.sql` 和 `<版本號>_<名稱>.down.sql` ，例如 `0001_create_users.up.sql` 。
+// 其他檔案會被忽略。每個版本必須有升級腳本，降級腳本可選。
+//
+// 返回值:
+//   - []Migration: 按版本號排序的遷移。
+//   - error: 讀取失敗、檔名無法解析或版本號重複時返回錯誤。
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		verStr, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(verStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("nyamysql: invalid migration file name %q", file)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("nyamysql: duplicate migration version %d (%s, %s)", version, m.Name, name)
+		}
+		if direction == "up" {
+			if m.Up != "" {
+				return nil, fmt.Errorf("nyamysql: duplicate migration version %d", version)
+			}
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("nyamysql: migration %d_%s has no up script", m.Version, m.Name)
+		}
+		m.Checksum = migrationChecksum(m.Up, m.Down)
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// UpStatements 返回升級腳本拆分後的各條語句。
+func (m Migration) UpStatements() []string {
+	return splitStatements(m.Up)
+}
+
+// DownStatements 返回降級腳本拆分後的各條語句。
+func (m Migration) DownStatements() []string {
+	return splitStatements(m.Down)
+}
+
+// NewMigrator 建立一個遷移執行器。
+//
+// 引數:
+//   - fsys: 遷移腳本所在的檔案系統，可以使用 embed.FS 或 os.DirFS 。
+//   - dir: 遷移腳本所在的目錄，根目錄填寫 "." 。
+//   - opts: 遷移配置。
+//
+// 返回值:
+//   - *Migrator: 遷移執行器。
+//   - error: 讀取遷移腳本失敗時返回錯誤。
+func (p *NyaMySQL) NewMigrator(fsys fs.FS, dir string, opts MigrateOptions) (*Migrator, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	migrations, err := LoadMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Table == "" {
+		opts.Table = DefaultMigrationTable
+	}
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = 30 * time.Second
+	}
+	return &Migrator{p: p, migrations: migrations, opts: opts}, nil
+}
+
+// Migrations 返回已載入的所有遷移。
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up 依次執行所有尚未執行的遷移。
+// 執行前會校驗已執行遷移的校驗值，有遷移被修改時返回 *ChecksumMismatchError 且不執行任何遷移。
+//
+// 返回值:
+//   - []Migration: 本次執行（DryRun 時為將要執行）的遷移。
+//   - error: 取得鎖、校驗或執行失敗時返回錯誤，已成功執行的遷移仍會被記錄。
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo 同 Up ，但只執行版本號小於等於 version 的遷移，version 為 0 時執行全部。
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]Migration, error) {
+	done := []Migration{}
+	err := m.session(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if version > 0 && mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, "MigrateUp", mig, mig.UpStatements()); err != nil {
+				return err
+			}
+			record := "INSERT INTO `" + m.opts.Table + "` (`version`,`name`,`checksum`) VALUES (?,?,?)"
+			if err := m.exec(ctx, conn, "MigrateUp", record, mig.Version, mig.Name, mig.Checksum); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down 按版本號從大到小回滾最近執行的 steps 個遷移。
+//
+// 返回值:
+//   - []Migration: 本次回滾（DryRun 時為將要回滾）的遷移。
+//   - error: 遷移沒有降級腳本、在遷移來源中找不到或執行失敗時返回錯誤。
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	done := []Migration{}
+	err := m.session(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+		byVersion := map[int64]Migration{}
+		for _, mig := range m.migrations {
+			byVersion[mig.Version] = mig
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for i := 0; i < steps && i < len(versions); i++ {
+			mig, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("nyamysql: applied migration %d not found in source", versions[i])
+			}
+			if strings.TrimSpace(mig.Down) == "" {
+				return fmt.Errorf("nyamysql: migration %d_%s has no down script", mig.Version, mig.Name)
+			}
+			if err := m.run(ctx, conn, "MigrateDown", mig, mig.DownStatements()); err != nil {
+				return err
+			}
+			record := "DELETE FROM `" + m.opts.Table + "` WHERE `version`=?"
+			if err := m.exec(ctx, conn, "MigrateDown", record, mig.Version); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status 返回所有遷移（包括只存在於資料庫記錄中的版本）的執行狀態，按版本號排序。
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.p.check(); err != nil {
+		return nil, err
+	}
+	conn, err := m.p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	status := []MigrationStatus{}
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.AppliedAt
+			delete(applied, mig.Version)
+		}
+		status = append(status, s)
+	}
+	for _, a := range applied {
+		status = append(status, a)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Verify 校驗所有已執行遷移的校驗值，有遷移被修改時返回 *ChecksumMismatchError 。
+func (m *Migrator) Verify(ctx context.Context) error {
+	if err := m.p.check(); err != nil {
+		return err
+	}
+	conn, err := m.p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return m.verify(applied)
+}
+
+// session: 在一個獨立連線上取得遷移鎖並執行 fn 。DryRun 時不取得鎖也不建立記錄表。
+func (m *Migrator) session(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	if err := m.p.check(); err != nil {
+		return err
+	}
+	conn, err := m.p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.opts.DryRun != nil {
+		return fn(conn)
+	}
+
+	lockName := m.opts.LockName
+	if lockName == "" {
+		var dbName sql.NullString
+		if err := m.queryRow(ctx, conn, "SELECT DATABASE()", nil, &dbName); err != nil {
+			return err
+		}
+		lockName = "nyamysql:" + dbName.String + "." + m.opts.Table
+	}
+	var got sql.NullInt64
+	if err := m.queryRow(ctx, conn, "SELECT GET_LOCK(?, ?)", []interface{}{lockName, int(m.opts.LockTimeout / time.Second)}, &got); err != nil {
+		return err
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return ErrMigrationLocked
+	}
+	defer m.p.execOnce(context.Background(), conn, "Migrate", "DO RELEASE_LOCK(?)", []interface{}{lockName})
+
+	create := "CREATE TABLE IF NOT EXISTS `" + m.opts.Table + "` (\n" +
+		"  `version` BIGINT NOT NULL,\n" +
+		"  `name` VARCHAR(255) NOT NULL,\n" +
+		"  `checksum` CHAR(64) NOT NULL,\n" +
+		"  `applied_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"  PRIMARY KEY (`version`)\n" +
+		")"
+	if err := m.exec(ctx, conn, "Migrate", create); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// applied: 讀取已執行的遷移，記錄表不存在時視為沒有已執行的遷移
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]MigrationStatus, error) {
+	applied := map[int64]MigrationStatus{}
+	var exists int
+	err := m.queryRow(ctx, conn, "SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", []interface{}{m.opts.Table}, &exists)
+	if err != nil || exists == 0 {
+		return applied, err
+	}
+	rows, err := m.p.queryOnce(ctx, conn, "Migrate", "SELECT `version`,`name`,`checksum`,`applied_at` FROM `"+m.opts.Table+"`", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = iterateRows(rows, func(r *Row) error {
+		var (
+			s         MigrationStatus
+			appliedAt []byte
+		)
+		if err := r.Scan(&s.Version, &s.Name, &s.Checksum, &appliedAt); err != nil {
+			return err
+		}
+		s.Applied = true
+		s.AppliedAt, _ = parseTime(string(appliedAt))
+		applied[s.Version] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// queryRow: 在遷移的連線上執行查詢並掃描第一行，經過鉤子但不重試
+func (m *Migrator) queryRow(ctx context.Context, conn *sql.Conn, dbq string, values []interface{}, dest ...interface{}) error {
+	rows, err := m.p.queryOnce(ctx, conn, "Migrate", dbq, values)
+	if err != nil {
+		return err
+	}
+	found := false
+	err = iterateRows(rows, func(r *Row) error {
+		found = true
+		if err := r.Scan(dest...); err != nil {
+			return err
+		}
+		return ErrStopIteration
+	})
+	if err == nil && !found {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// verify: 比對已執行遷移的校驗值
+func (m *Migrator) verify(applied map[int64]MigrationStatus) error {
+	for _, mig := range m.migrations {
+		a, ok := applied[mig.Version]
+		if ok && a.Checksum != mig.Checksum {
+			return &ChecksumMismatchError{Version: mig.Version, Name: mig.Name, Expected: a.Checksum, Actual: mig.Checksum}
+		}
+	}
+	return nil
+}
+
+// run: 依次執行一個遷移的各條語句
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, tag string, mig Migration, statements []string) error {
+	if m.opts.DryRun != nil {
+		fmt.Fprintf(m.opts.DryRun, "-- %d_%s\n", mig.Version, mig.Name)
+	}
+	for _, stmt := range statements {
+		if err := m.exec(ctx, conn, tag, stmt); err != nil {
+			return fmt.Errorf("nyamysql: migration %d_%s failed: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// exec: 執行一條語句，經過鉤子但不重試（DDL 不能安全地重複執行），DryRun 時只輸出語句
+func (m *Migrator) exec(ctx context.Context, conn *sql.Conn, tag string, stmt string, values ...interface{}) error {
+	if m.opts.DryRun != nil {
+		_, err := fmt.Fprintf(m.opts.DryRun, "%s;\n", dbPrintStr(stmt, values))
+		return err
+	}
+	_, err := m.p.execOnce(ctx, conn, tag, stmt, values)
+	return err
+}
+
+// migrationChecksum: 計算升級和降級腳本的 SHA-256 ，沒有降級腳本時只計算升級腳本
+func migrationChecksum(up string, down string) string {
+	script := up
+	if down != "" {
+		script += "\x00" + down
+	}
+	sum := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitStatements: 按分號拆分腳本，忽略引號和註解中的分號，並去除空語句
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		quote      byte // 目前所在的引號，0 表示不在引號中
+	)
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if quote != 0 {
+			current.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(script) {
+				i++
+				current.WriteByte(script[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteByte(c)
+		case c == '#' || (c == '-' && strings.HasPrefix(script[i:], "-- ")) || (c == '-' && strings.HasPrefix(script[i:], "--\n")):
+			// 單行註解
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			current.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			// 區塊註解
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			current.WriteByte(' ')
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
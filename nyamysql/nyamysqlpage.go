@@ -0,0 +1,318 @@
+// MySQL 游標分頁
+package nyamysql
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPageToken 表示分頁令牌無法解析或不屬於此查詢（Table 、Where 、OrderBy 或軟刪除的查詢方式與生成令牌時不同）。
+var ErrInvalidPageToken = errors.New("nyamysql: invalid page token")
+
+// PageOrder 是分頁排序中的一列。
+type PageOrder struct {
+	Column string
+	Desc   bool
+}
+
+// PageQuery 是游標分頁的查詢。
+//
+//   - Columns: 要查詢的欄位，同 QueryData 的 `recn` ，為空時為 * 。必須包含 OrderBy 中的所有列（可以是別名），否則 Paginate 在查詢前返回錯誤。
+//   - Table: 表名，不需要反引號包裹。
+//   - Where: 查詢條件，不需要 where 關鍵字，可以為空。
+//   - Values: Where 中佔位符的值。
+//   - OrderBy: 排序的列，組合起來必須唯一（通常以主鍵結尾），且不能為 NULL 。
+//   - Size: 每頁行數，小於等於 0 時使用 20 。
+//   - Count: 是否同時查詢符合條件的總行數。
+type PageQuery struct {
+	Columns string
+	Table   string
+	Where   string
+	Values  []interface{}
+	OrderBy []PageOrder
+	Size    int
+	Count   bool
+}
+
+// Page 是一頁查詢結果。
+//
+//   - Rows: 按 OrderBy 順序排列的行。
+//   - Next: 下一頁的令牌，沒有下一頁時為空。
+//   - Prev: 上一頁的令牌，沒有上一頁時為空。
+//   - Total: 符合條件的總行數，未要求 Count 時為 -1 。
+type Page struct {
+	Rows  []map[string]string
+	Next  string
+	Prev  string
+	Total int64
+}
+
+// pageToken: 令牌的內容，Dir 為 "n"（向後）或 "p"（向前），Key 為邊界行的排序列的值，
+// Query 為 pageQueryHash 的結果，用於拒絕其他查詢的令牌
+type pageToken struct {
+	Dir   string   `json:"d"`
+	Key   []string `json:"k"`
+	Query string   `json:"q"`
+}
+
+// Paginate 以游標（keyset）方式分頁查詢，深分頁時效能不會像 OFFSET 一樣下降。
+//
+// 第一頁傳入空的 `token` ，之後傳入上一次返回的 Page.Next 或 Page.Prev 。
+// 令牌是 base64 編碼的邊界行排序列的值，呼叫方不應解析或修改。
+// 表設定了軟刪除（SetSoftDelete）時同 QueryData 排除已刪除的行。
+//
+// 引數:
+//   - ctx: 上下文。
+//   - q: 分頁查詢，翻頁時應保持不變。
+//   - token: 分頁令牌，第一頁為空。
+//
+// 返回值:
+//   - *Page: 查詢結果和前後頁的令牌。
+//   - error: 查詢失敗、令牌無效（ErrInvalidPageToken）或 Columns 沒有包含 OrderBy 中的列時返回錯誤。
+func (p *NyaMySQL) Paginate(ctx context.Context, q PageQuery, token string) (*Page, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if len(q.OrderBy) == 0 {
+		return nil, fmt.Errorf("nyamysql: Paginate requires OrderBy")
+	}
+	if err := pageColumnsCheck(q.Columns, q.OrderBy); err != nil {
+		return nil, err
+	}
+	size := q.Size
+	if size <= 0 {
+		size = 20
+	}
+	// 以附加軟刪除條件後的 Where 計算雜湊，WithTrashed 、OnlyTrashed 的令牌不能用於預設的查詢
+	q.Where = p.softDeleteWhere(q.Table, q.Table, true, q.Where)
+	hash := pageQueryHash(q)
+	var cursor *pageToken
+	if token != "" {
+		t, err := decodePageToken(token, len(q.OrderBy), hash)
+		if err != nil {
+			return nil, err
+		}
+		cursor = t
+	}
+	backward := cursor != nil && cursor.Dir == "p"
+
+	dbq, values := pageSQL(q, cursor, backward, size+1)
+	rows, err := p.readContext(ctx, "Paginate", dbq, values)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{Rows: []map[string]string{}, Total: -1}
+	err = iterateRows(rows, func(r *Row) error {
+		row, err := r.Map()
+		if err == nil {
+			page.Rows = append(page.Rows, row)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	more := len(page.Rows) > size
+	if more {
+		page.Rows = page.Rows[:size]
+	}
+	if backward {
+		for i, j := 0, len(page.Rows)-1; i < j; i, j = i+1, j-1 {
+			page.Rows[i], page.Rows[j] = page.Rows[j], page.Rows[i]
+		}
+	}
+	if n := len(page.Rows); n > 0 {
+		// 向後翻頁時，有多餘的行表示還有下一頁，帶有令牌表示有上一頁；向前翻頁時相反
+		if (!backward && more) || backward {
+			if page.Next, err = encodePageToken("n", q.OrderBy, hash, page.Rows[n-1]); err != nil {
+				return nil, err
+			}
+		}
+		if (backward && more) || (!backward && cursor != nil) {
+			if page.Prev, err = encodePageToken("p", q.OrderBy, hash, page.Rows[0]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if q.Count {
+		dbq := "SELECT COUNT(*) FROM " + quoteIdent(q.Table)
+		if q.Where != "" {
+			dbq += " WHERE " + q.Where
+		}
+		rows, err := p.readContext(ctx, "Paginate", dbq, q.Values)
+		if err != nil {
+			return nil, err
+		}
+		err = iterateRows(rows, func(r *Row) error {
+			return r.Scan(&page.Total)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// Paginate: 同 NyaMySQL.Paginate ，在交易中執行
+func (t *Tx) Paginate(ctx context.Context, q PageQuery, token string) (*Page, error) {
+	return t.p.Paginate(ctx, q, token)
+}
+
+// pageSQL: 生成分頁查詢語句。向前翻頁時反轉排序方向，結果需要由呼叫方再反轉。
+func pageSQL(q PageQuery, cursor *pageToken, backward bool, limit int) (string, []interface{}) {
+	columns := q.Columns
+	if columns == "" {
+		columns = "*"
+	}
+	dbq := "SELECT " + columns + " FROM " + quoteIdent(q.Table)
+	values := append([]interface{}{}, q.Values...)
+
+	var conds []string
+	if q.Where != "" {
+		conds = append(conds, "("+q.Where+")")
+	}
+	if cursor != nil {
+		// (a > ?) OR (a = ? AND b > ?) OR ... ，每列按自身的排序方向選擇比較符
+		var ors []string
+		for i, o := range q.OrderBy {
+			var ands []string
+			for j := 0; j < i; j++ {
+				ands = append(ands, quoteColumn(q.OrderBy[j].Column)+" = ?")
+				values = append(values, cursor.Key[j])
+			}
+			op := ">"
+			if o.Desc != backward {
+				op = "<"
+			}
+			ands = append(ands, quoteColumn(o.Column)+" "+op+" ?")
+			values = append(values, cursor.Key[i])
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+		conds = append(conds, "("+strings.Join(ors, " OR ")+")")
+	}
+	if len(conds) > 0 {
+		dbq += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	orders := make([]string, len(q.OrderBy))
+	for i, o := range q.OrderBy {
+		dir := "ASC"
+		if o.Desc != backward {
+			dir = "DESC"
+		}
+		orders[i] = quoteColumn(o.Column) + " " + dir
+	}
+	dbq += fmt.Sprintf(" ORDER BY %s LIMIT %d", strings.Join(orders, ", "), limit)
+	return dbq, values
+}
+
+// encodePageToken: 以邊界行的排序列的值生成令牌，邊界行沒有某個排序列時返回錯誤
+func encodePageToken(dir string, orderBy []PageOrder, hash string, row map[string]string) (string, error) {
+	t := pageToken{Dir: dir, Key: make([]string, len(orderBy)), Query: hash}
+	for i, o := range orderBy {
+		v, ok := row[columnKey(o.Column)]
+		if !ok {
+			return "", fmt.Errorf("nyamysql: Paginate: OrderBy column %q is not in Columns", o.Column)
+		}
+		t.Key[i] = v
+	}
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageToken: 解析令牌並檢查排序列的數量和所屬的查詢
+func decodePageToken(token string, keys int, hash string) (*pageToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var t pageToken
+	if err := json.Unmarshal(data, &t); err != nil || (t.Dir != "n" && t.Dir != "p") || len(t.Key) != keys || t.Query != hash {
+		return nil, ErrInvalidPageToken
+	}
+	return &t, nil
+}
+
+// pageQueryHash: 以 Table 、Where（已附加軟刪除條件）和 OrderBy 計算查詢的雜湊值
+func pageQueryHash(q PageQuery) string {
+	h := fnv.New64a()
+	h.Write([]byte(q.Table + "\x00" + q.Where))
+	for _, o := range q.OrderBy {
+		h.Write([]byte("\x00" + o.Column + "\x00" + strconv.FormatBool(o.Desc)))
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// pageColumnsCheck: 檢查 Columns 包含 OrderBy 中的所有列，Columns 為空或含有 * 時不檢查
+func pageColumnsCheck(columns string, orderBy []PageOrder) error {
+	names := map[string]bool{}
+	for _, col := range splitColumns(columns) {
+		if col == "" || strings.HasSuffix(col, "*") {
+			return nil
+		}
+		// 別名為最後一個詞（`expr AS name` 或 `expr name`），否則為欄位名本身
+		if i := strings.LastIndexAny(col, " \t\n"); i >= 0 {
+			col = col[i+1:]
+		}
+		names[strings.ToLower(columnKey(col))] = true
+	}
+	for _, o := range orderBy {
+		if !names[strings.ToLower(columnKey(o.Column))] {
+			return fmt.Errorf("nyamysql: Paginate: OrderBy column %q is not in Columns", o.Column)
+		}
+	}
+	return nil
+}
+
+// splitColumns: 按不在括號或引號中的逗號分割欄位列表
+func splitColumns(columns string) []string {
+	var cols []string
+	depth, start := 0, 0
+	var quote rune
+	for i, c := range columns {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '`' || c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			cols = append(cols, strings.TrimSpace(columns[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cols, strings.TrimSpace(columns[start:]))
+}
+
+// quoteColumn: 以反引號包裹欄位名，支援 table.column 形式，已包裹的欄位名原樣返回
+func quoteColumn(column string) string {
+	if strings.Contains(column, "`") {
+		return column
+	}
+	parts := strings.Split(column, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdent(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// columnKey: 返回欄位在查詢結果中的名稱，即去掉表名和反引號後的部分
+func columnKey(column string) string {
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		column = column[i+1:]
+	}
+	return strings.Trim(column, "`")
+}
@@ -2,120 +2,462 @@
 package nyamysql
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"sync"
 	"time"
 )
 
+// DefaultPoolName 是 NewPool / NewPoolC 註冊的實例名稱。
+const DefaultPoolName = "default"
+
+var (
+	// ErrPoolClosed 表示連線池已經關閉。
+	ErrPoolClosed = errors.New("nyamysql: pool is closed")
+	// ErrPoolNotFound 表示沒有以該名稱註冊的實例。
+	ErrPoolNotFound = errors.New("nyamysql: pool entry not found")
+)
+
+// PoolOptions 是 MySQLPool 的配置。
+//
+//   - IdleTimeout: 實例沒有被借用超過此時間後關閉，下次借用時重新連線。0 表示不回收。
+//   - HealthCheckInterval: 借出實例前距離上次成功 Ping 超過此時間則重新 Ping 。0 使用預設的 30 秒，負數表示不檢查。
+//   - Debug: 傳給每個 NyaMySQL 實例的日誌記錄器。
+//   - LogLevel: 傳給每個 NyaMySQL 實例的日誌級別。
+type PoolOptions struct {
+	IdleTimeout         time.Duration
+	HealthCheckInterval time.Duration
+	Debug               *log.Logger
+	LogLevel            int
+}
+
+// PoolStats 是連線池中一個實例的統計資訊。
+//
+//   - Name: 實例名稱。
+//   - Open: 實例目前是否已連線。
+//   - InUse: 目前借出的數量。
+//   - MaxInUse: 同時借出的上限，0 表示不限制。
+//   - Acquired: 累計借出次數。
+//   - WaitCount: 因達到上限而等待的累計次數。
+//   - WaitDuration: 累計等待時間。
+//   - Opened: 累計建立連線的次數。
+//   - Evicted: 因閒置而被關閉的次數。
+//   - HealthCheckFailed: Ping 失敗的次數。
+//   - DB: 底層 sql.DB 的統計資訊，實例未連線時為零值。
+type PoolStats struct {
+	Name              string
+	Open              bool
+	InUse             int
+	MaxInUse          int
+	Acquired          int64
+	WaitCount         int64
+	WaitDuration      time.Duration
+	Opened            int64
+	Evicted           int64
+	HealthCheckFailed int64
+	DB                sql.DBStats
+}
+
+// MySQLPool 管理多個具名的 NyaMySQL 實例（例如每個租戶一個資料庫），可以安全地在多個 goroutine 中使用。
+// 每個實例本身由 database/sql 維護連線池，MySQLPool 負責限制同時借出的數量、延遲連線、健康檢查和閒置回收。
 type MySQLPool MySQLPoolT
 type MySQLPoolT struct {
-	mySQLConfig   MySQLDBConfig
-	mySQLLink     int
-	mySQLLinkMax  int
-	mySQLPoolList []*NyaMySQL
-	waitCount     int
-	waitTime      int
-	err           error
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+	opts    PoolOptions
+	stop    chan struct{}
+	closed  bool
+	err     error
+}
+
+// poolEntry: 連線池中的一個具名實例
+type poolEntry struct {
+	mu        sync.Mutex
+	name      string
+	config    MySQLDBConfig
+	db        *NyaMySQL
+	sem       chan struct{} // 借出許可，為 nil 時不限制
+	lastUsed  time.Time
+	lastCheck time.Time
+	checking  int  // 正在進行的健康檢查，大於 0 時實例不會被關閉
+	closed    bool // 已從連線池移除或連線池已關閉
+	stats     PoolStats
 }
 
 // NewPool: 建立新的 NyaMySQL 池，代替 New 。
+// 配置以 DefaultPoolName 註冊，`linkMax` 為同時借出的上限。
 func NewPool(configJsonString string, linkMax int) *MySQLPool {
 	var mySQLConfig MySQLDBConfig
 	err := json.Unmarshal([]byte(configJsonString), &mySQLConfig)
 	if err != nil {
-		return &MySQLPool{err: err}
+		// 仍然建立完整的連線池，使之後的呼叫返回錯誤而不是 panic
+		p := NewPoolWithOptions(PoolOptions{})
+		p.err = err
+		return p
 	}
 	return NewPoolC(mySQLConfig, linkMax)
 }
 
 // NewPoolC: 同上, `configJsonString` 改為 `mySQLConfig` 以支援直接配置輸入
 func NewPoolC(mySQLConfig MySQLDBConfig, linkMax int) *MySQLPool {
-	var o = &MySQLPool{
-		mySQLConfig:   mySQLConfig,
-		mySQLLink:     0,
-		mySQLLinkMax:  linkMax,
-		mySQLPoolList: []*NyaMySQL{},
-		waitCount:     10,
-		waitTime:      500,
-		err:           nil,
+	p := NewPoolWithOptions(PoolOptions{})
+	p.err = p.Register(DefaultPoolName, mySQLConfig, linkMax)
+	return p
+}
+
+// NewPoolWithOptions 建立一個空的連線池，之後使用 Register 註冊實例。
+//
+// 引數:
+//   - opts: 連線池配置。
+//
+// 返回值:
+//   - *MySQLPool: 連線池，不再使用時需呼叫 Close 。
+func NewPoolWithOptions(opts PoolOptions) *MySQLPool {
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = 30 * time.Second
 	}
-	for i := 0; i < o.mySQLLinkMax; i++ {
-		o.mySQLPoolList = append(o.mySQLPoolList, nil)
+	p := &MySQLPool{
+		entries: map[string]*poolEntry{},
+		opts:    opts,
+		stop:    make(chan struct{}),
 	}
-	return o
+	if opts.IdleTimeout > 0 {
+		go p.janitor()
+	}
+	return p
+}
+
+// Error 返回建立連線池時發生的錯誤。
+// 有錯誤時 Register 、Acquire 等方法都會返回此錯誤。
+func (p *MySQLPool) Error() error {
+	return p.err
 }
 
-// mysqlIsRun: 檢查並建立新的 MySQL 連線。
+// check: 返回連線池目前不可用的原因。呼叫方需持有 p.mu 。
+func (p *MySQLPool) check() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.closed || p.entries == nil {
+		return ErrPoolClosed
+	}
+	return nil
+}
+
+// Register 註冊一個具名實例。實例在第一次 Acquire 時才會連線。
 //
-// 若連線數已達上限，則等待可用連線。
-// 引數 isShowPrint 控制是否列印錯誤資訊。
-// 返回值：新建的 MySQL 連線索引（或 -1 表示失敗），以及可能的錯誤資訊。
-func (p *MySQLPool) MysqlIsRun(isShowPrint bool) (int, error) {
-	// 如果連線數已滿，則進入等待
-	if p.mySQLLink >= p.mySQLLinkMax {
-		wc := 0
-		for {
-			if p.mySQLLink < p.mySQLLinkMax {
-				break // 退出等待
-			}
-			if wc > p.waitCount {
-				return -1, fmt.Errorf("MySQL connections are full") // 連線數超限
+// 引數:
+//   - name: 實例名稱。
+//   - mySQLConfig: 實例的連線配置。
+//   - maxInUse: 同時借出的上限，小於等於 0 表示不限制。
+//
+// 返回值:
+//   - error: 名稱已存在、連線池建立失敗或已關閉時返回錯誤。
+func (p *MySQLPool) Register(name string, mySQLConfig MySQLDBConfig, maxInUse int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if _, ok := p.entries[name]; ok {
+		return fmt.Errorf("nyamysql: pool entry %q already registered", name)
+	}
+	e := &poolEntry{name: name, config: mySQLConfig}
+	if maxInUse > 0 {
+		e.sem = make(chan struct{}, maxInUse)
+		e.stats.MaxInUse = maxInUse
+	}
+	p.entries[name] = e
+	return nil
+}
+
+// Unregister 移除一個具名實例並關閉其連線。已借出的實例在移除後不應繼續使用。
+func (p *MySQLPool) Unregister(name string) error {
+	p.mu.Lock()
+	if err := p.check(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	e, ok := p.entries[name]
+	delete(p.entries, name)
+	p.mu.Unlock()
+	if !ok {
+		return ErrPoolNotFound
+	}
+	e.shutdown()
+	return nil
+}
+
+// Names 返回所有已註冊的實例名稱。
+func (p *MySQLPool) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.entries))
+	for name := range p.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Acquire 借出一個具名實例。達到同時借出上限時阻塞，直到有實例被歸還、ctx 結束或連線池關閉。
+// 借出的實例使用完畢後必須呼叫 Release 歸還，且不應呼叫其 Close 。
+//
+// 引數:
+//   - ctx: 上下文，用於取消等待或設定逾時。
+//   - name: 實例名稱。
+//
+// 返回值:
+//   - *NyaMySQL: 可用的實例。
+//   - error: 等待被取消、連線失敗或健康檢查失敗時返回錯誤。連線和健康檢查同樣可以透過 ctx 取消。
+func (p *MySQLPool) Acquire(ctx context.Context, name string) (*NyaMySQL, error) {
+	e, err := p.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if e.sem != nil {
+		select {
+		case e.sem <- struct{}{}:
+		default:
+			start := time.Now()
+			select {
+			case e.sem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-p.stop:
+				return nil, ErrPoolClosed
 			}
-			wc += 1
-			time.Sleep(time.Duration(p.waitTime) * time.Millisecond) // 休眠等待
+			e.mu.Lock()
+			e.stats.WaitCount++
+			e.stats.WaitDuration += time.Since(start)
+			e.mu.Unlock()
 		}
 	}
 
-	// 建立新的 MySQL 連線
-	nyaMS := NewC(p.mySQLConfig, parametersSave.Debug, parametersSave.loggerLevel)
-	if nyaMS.Error() != nil {
-		if isShowPrint {
-			println("MySQL DB Link error:", nyaMS.Error().Error())
+	db, err := e.acquire(ctx, p.opts)
+	if err != nil {
+		if e.sem != nil {
+			<-e.sem
 		}
-		return -1, nyaMS.Error()
+		return nil, err
 	}
+	return db, nil
+}
 
-	// 查詢空位存放新連線
-	ii := 0
-	for i := 0; i < len(p.mySQLPoolList); i++ {
-		if p.mySQLPoolList[i] == nil {
-			ii = i
-			break
-		}
+// Release 歸還由 Acquire 借出的實例。
+func (p *MySQLPool) Release(name string) {
+	e, err := p.entry(name)
+	if err != nil {
+		return
 	}
+	e.mu.Lock()
+	if e.stats.InUse == 0 {
+		e.mu.Unlock()
+		return
+	}
+	e.stats.InUse--
+	e.lastUsed = time.Now()
+	e.mu.Unlock()
+	if e.sem != nil {
+		<-e.sem
+	}
+}
 
-	// 記錄新的連線
-	p.mySQLLink += 1
-	p.mySQLPoolList[ii] = nyaMS
-	fmt.Println("MySQL connection successful!")
-	fmt.Println("MySQL Link Number:", p.mySQLLink, ii, len(p.mySQLPoolList))
-	return ii, nil
+// Stats 返回一個具名實例的統計資訊。
+func (p *MySQLPool) Stats(name string) (PoolStats, error) {
+	e, err := p.entry(name)
+	if err != nil {
+		return PoolStats{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.snapshot(), nil
 }
 
-// mysqlClose: 關閉指定索引的 MySQL 連線。
-//
-// 引數 i 表示要關閉的連線索引，isShowPrint 控制是否列印關閉資訊。
-func (p *MySQLPool) MysqlClose(i int, isShowPrint bool) {
-	// 檢查索引是否有效
-	if i < 0 || i >= len(p.mySQLPoolList) {
-		fmt.Println("MySQL is Close!", p.mySQLLink, i, len(p.mySQLPoolList))
+// AllStats 返回所有實例的統計資訊，按名稱排序。
+func (p *MySQLPool) AllStats() []PoolStats {
+	stats := []PoolStats{}
+	for _, name := range p.Names() {
+		if s, err := p.Stats(name); err == nil {
+			stats = append(stats, s)
+		}
+	}
+	return stats
+}
+
+// Close 關閉連線池及其中所有實例，正在等待 Acquire 的呼叫會返回 ErrPoolClosed 。
+func (p *MySQLPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
 		return
 	}
+	p.closed = true
+	if p.stop != nil {
+		close(p.stop)
+	}
+	entries := p.entries
+	p.entries = map[string]*poolEntry{}
+	p.mu.Unlock()
 
-	// 關閉連線並釋放資源
-	if p.mySQLPoolList[i] != nil {
-		fmt.Println("Close MySQL Link!", p.mySQLLink, i, len(p.mySQLPoolList))
-		p.mySQLPoolList[i].Close()
-		p.mySQLPoolList[i] = nil
-		p.mySQLLink -= 1
+	for _, e := range entries {
+		e.shutdown()
+	}
+}
+
+// entry: 按名稱查詢實例
+func (p *MySQLPool) entry(name string) (*poolEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	e, ok := p.entries[name]
+	if !ok {
+		return nil, ErrPoolNotFound
+	}
+	return e, nil
+}
 
-		// 防止連線數低於 0
-		if p.mySQLLink < 0 {
-			p.mySQLLink = 0
+// janitor: 定期關閉閒置的實例，直到連線池關閉
+func (p *MySQLPool) janitor() {
+	interval := p.opts.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.evictIdle(now)
 		}
+	}
+}
 
-		if isShowPrint {
-			println("MySQL Close Connection! Current number of connections:", p.mySQLLink)
+// evictIdle: 關閉沒有被借用且閒置超過 IdleTimeout 的實例
+func (p *MySQLPool) evictIdle(now time.Time) {
+	p.mu.Lock()
+	entries := make([]*poolEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	p.mu.Unlock()
+
+	for _, e := range entries {
+		e.mu.Lock()
+		if e.db != nil && e.stats.InUse == 0 && e.checking == 0 && now.Sub(e.lastUsed) > p.opts.IdleTimeout {
+			e.close()
+			e.stats.Evicted++
 		}
+		e.mu.Unlock()
+	}
+}
+
+// acquire: 借出實例並更新統計，必要時建立連線或進行健康檢查。
+// 連線和 Ping 在 e.mu 之外進行，不會阻塞同一實例的 Stats 、Release 等呼叫。
+// Ping 期間 e.checking 阻止閒置回收、其他健康檢查和 shutdown 關閉同一實例。
+// 多個呼叫同時建立連線時只保留一個，其餘的會被關閉。
+func (e *poolEntry) acquire(ctx context.Context, opts PoolOptions) (*NyaMySQL, error) {
+	e.mu.Lock()
+	db := e.db
+	check := db != nil && !e.closed && opts.HealthCheckInterval > 0 && time.Since(e.lastCheck) > opts.HealthCheckInterval
+	if check {
+		e.checking++
+	}
+	e.mu.Unlock()
+	if check {
+		err := db.PingContext(ctx)
+		e.mu.Lock()
+		e.checking--
+		if e.closed {
+			// 健康檢查期間實例被移除，由最後一個健康檢查關閉
+			if e.checking == 0 {
+				e.close()
+			}
+			e.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if err == nil {
+			e.lastCheck = time.Now()
+		} else {
+			e.stats.HealthCheckFailed++
+			if e.stats.InUse > 0 || e.checking > 0 {
+				// 仍有其他借用者或健康檢查時不能關閉實例，只返回錯誤
+				e.mu.Unlock()
+				return nil, err
+			}
+			e.close()
+		}
+		e.mu.Unlock()
+	}
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if e.db == nil {
+		e.mu.Unlock()
+		fresh := newContext(ctx, e.config, opts.Debug, opts.LogLevel)
+		if fresh.Error() != nil {
+			return nil, fresh.Error()
+		}
+		e.mu.Lock()
+		if e.closed {
+			// 連線期間連線池已關閉或實例已移除
+			e.mu.Unlock()
+			fresh.Close()
+			return nil, ErrPoolClosed
+		}
+		if e.db == nil {
+			e.db = fresh
+			e.lastCheck = time.Now()
+			e.stats.Opened++
+		} else {
+			fresh.Close()
+		}
+	}
+	e.stats.InUse++
+	e.stats.Acquired++
+	db = e.db
+	e.mu.Unlock()
+	return db, nil
+}
+
+// shutdown: 標記為已移除並關閉實例，之後的 acquire 返回 ErrPoolClosed 。
+// 有健康檢查正在進行時由最後一個健康檢查關閉實例。
+func (e *poolEntry) shutdown() {
+	e.mu.Lock()
+	e.closed = true
+	if e.checking == 0 {
+		e.close()
+	}
+	e.mu.Unlock()
+}
+
+// close: 關閉實例的連線。呼叫方需持有 e.mu 。
+func (e *poolEntry) close() {
+	if e.db != nil {
+		e.db.Close()
+		e.db = nil
+	}
+}
+
+// snapshot: 返回目前的統計資訊。呼叫方需持有 e.mu 。
+func (e *poolEntry) snapshot() PoolStats {
+	s := e.stats
+	s.Name = e.name
+	s.Open = e.db != nil
+	if e.db != nil {
+		s.DB = e.db.Stats()
 	}
+	return s
 }
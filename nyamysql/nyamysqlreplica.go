@@ -0,0 +1,230 @@
+// MySQL 讀寫分離
+package nyamysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DefaultReplicaRetryInterval 是副本連線失敗後暫停使用的預設時間。
+const DefaultReplicaRetryInterval = 30 * time.Second
+
+var (
+	// 副本可以連線但無法使用的错误代码，遇到時換下一個副本並暫停使用此副本
+	replicaDownCode = []uint16{
+		1040, // Too many connections
+		1044, // Access denied for user to database
+		1045, // Access denied for user (using password)
+		1049, // Unknown database
+		1203, // User already has more than 'max_user_connections' active connections
+	}
+
+	// 副本可能尚未同步 DDL 的错误代码，遇到時換下一個副本，但不暫停使用此副本
+	replicaLagCode = []uint16{
+		1054, // Unknown column
+		1146, // Table doesn't exist
+	}
+)
+
+// forcePrimaryKey 是 WithForcePrimary 在 context 中使用的鍵
+type forcePrimaryKey struct{}
+
+// WithForcePrimary 返回一個要求查詢使用主庫的 context ，用於寫入後立即讀取的場景。
+func WithForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+// ReplicaStats 是一個唯讀副本的狀態。
+//
+//   - Address: 副本的地址和埠。
+//   - Healthy: 目前是否可用。
+//   - Failures: 累計連線失敗次數。
+//   - Err: 副本配置無效而無法開啟時的錯誤，這樣的副本不會被使用。
+//   - DB: 底層 sql.DB 的統計資訊。
+type ReplicaStats struct {
+	Address  string
+	Healthy  bool
+	Failures int64
+	Err      error
+	DB       sql.DBStats
+}
+
+// replicaSet: 一組唯讀副本，在 NyaMySQL 的副本（交易、ForcePrimary）之間共享
+type replicaSet struct {
+	replicas      []*replica
+	next          uint32
+	mu            sync.RWMutex
+	retryInterval time.Duration
+}
+
+// replica: 一個唯讀副本
+type replica struct {
+	db        *sql.DB // 配置無效時為 nil
+	err       error   // 配置無效時的錯誤
+	address   string
+	downUntil int64 // UnixNano，在此之前不使用此副本
+	failures  int64
+}
+
+// openReplicas: 根據配置開啟所有唯讀副本，沒有配置時返回 nil 。
+// Ping 失敗的副本會被暫時標記為不可用，配置無效的副本會一直不可用並記錄在 ReplicaStats 中，
+// 而不是讓整個實例建立失敗。
+func openReplicas(mySQLConfig MySQLDBConfig, Debug *log.Logger) *replicaSet {
+	if len(mySQLConfig.Replicas) == 0 {
+		return nil
+	}
+	rs := &replicaSet{retryInterval: DefaultReplicaRetryInterval}
+	for _, rc := range mySQLConfig.Replicas {
+		conf := mySQLConfig
+		conf.Address = rc.Address
+		conf.Port = rc.Port
+		conf.Socket = ""
+		if rc.User != "" {
+			conf.User = rc.User
+			conf.Password = rc.Password
+		}
+		r := &replica{address: net.JoinHostPort(rc.Address, rc.Port)}
+		rs.replicas = append(rs.replicas, r)
+		db, err := openDB(conf)
+		if err != nil {
+			r.err = err
+			atomic.AddInt64(&r.failures, 1)
+			if Debug != nil {
+				Debug.Printf("[Replica]open %s failed, error:[%v]", r.address, err)
+			}
+			continue
+		}
+		r.db = db
+		if err := db.Ping(); err != nil {
+			rs.markDown(r)
+			if Debug != nil {
+				Debug.Printf("[Replica]ping %s failed, error:[%v]", r.address, err)
+			}
+		}
+	}
+	return rs
+}
+
+// ForcePrimary 返回一個所有查詢都使用主庫的副本，與原實例共享連線。
+// 用於寫入後需要立即讀到最新資料的場景。
+func (p *NyaMySQL) ForcePrimary() *NyaMySQL {
+	np := *p
+	np.forcePrimary = true
+	return &np
+}
+
+// SetReplicaRetryInterval 設定副本連線失敗後暫停使用的時間。
+func (p *NyaMySQL) SetReplicaRetryInterval(d time.Duration) {
+	if p.replicas == nil {
+		return
+	}
+	p.replicas.mu.Lock()
+	p.replicas.retryInterval = d
+	p.replicas.mu.Unlock()
+}
+
+// ReplicaStats 返回所有唯讀副本的狀態，沒有配置副本時返回空切片。
+func (p *NyaMySQL) ReplicaStats() []ReplicaStats {
+	stats := []ReplicaStats{}
+	if p.replicas == nil {
+		return stats
+	}
+	now := time.Now().UnixNano()
+	for _, r := range p.replicas.replicas {
+		s := ReplicaStats{
+			Address:  r.address,
+			Healthy:  r.db != nil && atomic.LoadInt64(&r.downUntil) <= now,
+			Failures: atomic.LoadInt64(&r.failures),
+			Err:      r.err,
+		}
+		if r.db != nil {
+			s.DB = r.db.Stats()
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// readContext: 執行唯讀查詢。配置了副本且不在交易中、沒有要求使用主庫時，
+// 按輪詢順序使用健康的副本，全部不可用時使用主庫。
+// 副本連線失敗或伺服器拒絕存取（見 replicaDownCode）時暫停使用此副本並換下一個；
+// 表或欄位不存在（副本可能尚未同步 DDL ，見 replicaLagCode）時換下一個，但不暫停使用此副本。
+func (p *NyaMySQL) readContext(ctx context.Context, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	if p.replicas == nil || p.tx != nil || p.forcePrimary || ctx.Value(forcePrimaryKey{}) != nil {
+		return p.queryContext(ctx, tag, dbq, values)
+	}
+	for _, r := range p.replicas.candidates() {
+		query, err := p.queryOnce(ctx, r.db, tag, dbq, values)
+		if err == nil {
+			return query, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		switch {
+		case isConnError(err) || isMySQLError(err, replicaDownCode):
+			p.replicas.markDown(r)
+		case isMySQLError(err, replicaLagCode):
+		default:
+			return nil, err
+		}
+	}
+	return p.queryContext(ctx, tag, dbq, values)
+}
+
+// candidates: 按輪詢順序返回目前健康的副本
+func (rs *replicaSet) candidates() []*replica {
+	n := len(rs.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint32(&rs.next, 1)-1) % n
+	now := time.Now().UnixNano()
+	list := make([]*replica, 0, n)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+i)%n]
+		if r.db != nil && atomic.LoadInt64(&r.downUntil) <= now {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// markDown: 將副本標記為在 retryInterval 內不可用
+func (rs *replicaSet) markDown(r *replica) {
+	rs.mu.RLock()
+	interval := rs.retryInterval
+	rs.mu.RUnlock()
+	atomic.StoreInt64(&r.downUntil, time.Now().Add(interval).UnixNano())
+	atomic.AddInt64(&r.failures, 1)
+}
+
+// close: 關閉所有副本的連線
+func (rs *replicaSet) close() {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.replicas {
+		if r.db != nil {
+			r.db.Close()
+		}
+	}
+}
+
+// isConnError: 判斷錯誤是否由連線本身（而不是語句）造成
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,158 @@
+// MySQL 暫時性錯誤重試
+package nyamysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrNotConnected 表示實例為 nil 或已經關閉。
+	ErrNotConnected = errors.New("nyamysql: not connected")
+
+	// MySQL 暫時性错误代码，重試後可能成功
+	ErrTransientCode = []uint16{
+		1040, // Too many connections
+		1205, // Lock wait timeout exceeded; try restarting transaction
+		1213, // Deadlock found when trying to get lock; try restarting transaction
+		2006, // MySQL server has gone away
+		2013, // Lost connection to MySQL server during query
+	}
+
+	// MySQL 鎖衝突错误代码，語句已被回滾，可以安全地重新執行語句或整個交易
+	ErrDeadlockCode = []uint16{
+		1205, // Lock wait timeout exceeded; try restarting transaction
+		1213, // Deadlock found when trying to get lock; try restarting transaction
+	}
+
+	// 連線中斷類错误代码，語句可能已經在伺服器上執行，寫入時不重試
+	errConnLostCode = []uint16{2006, 2013}
+)
+
+// RetryPolicy 是實例的暫時性錯誤重試策略。
+//
+//   - MaxAttempts: 包括第一次在內的最多執行次數，小於等於 1 表示不重試。
+//   - InitialBackoff: 第一次重試前的等待時間。
+//   - MaxBackoff: 等待時間的上限，0 表示不限制。
+//   - Multiplier: 每次重試後等待時間的倍數，小於 1 時按 1 處理。
+//   - Jitter: 等待時間的隨機浮動比例（0 到 1），避免多個請求同時重試再次衝突。
+//   - Retryable: 判斷單條語句的錯誤是否可以重試，為 nil 時使用 IsTransientError 。
+//   - OnRetry: 每次重試前呼叫，傳入即將進行的第幾次重試、導致重試的錯誤和等待時間，可以為 nil 。
+//
+// 策略作用於交易之外的單條語句，以及 WithTx 的整個交易（僅限 ErrDeadlockCode 中的錯誤）。
+// 寫入語句遇到連線中斷（2006、2013、mysql.ErrInvalidConn）時不會重試，
+// 因為無法確定語句是否已經在伺服器上執行。
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+	Retryable      func(err error) bool
+	OnRetry        func(attempt int, err error, wait time.Duration)
+}
+
+// DefaultRetryPolicy 返回 NewC 使用的預設重試策略：最多執行 3 次，等待 100ms 起每次加倍，最多 2s ，浮動 20% 。
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// SetRetryPolicy 設定實例的重試策略，傳入 RetryPolicy{} 表示不重試。
+func (p *NyaMySQL) SetRetryPolicy(policy RetryPolicy) {
+	p.retry = policy
+}
+
+// IsTransientError 判斷錯誤是否為重試後可能成功的暫時性錯誤，
+// 包括 ErrTransientCode 中的 MySQL 錯誤和連線失效錯誤。
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	return isMySQLError(err, ErrTransientCode)
+}
+
+// IsDeadlockError 判斷錯誤是否為死鎖或鎖等待逾時（ErrDeadlockCode）。
+// 此類錯誤發生後語句（死鎖時是整個交易）已被回滾，重新執行是安全的。
+func IsDeadlockError(err error) bool {
+	return isMySQLError(err, ErrDeadlockCode)
+}
+
+// isMySQLError: 判斷錯誤是否為指定代碼之一的 *mysql.MySQLError
+func isMySQLError(err error, codes []uint16) bool {
+	var sqlErr *mysql.MySQLError
+	if !errors.As(err, &sqlErr) {
+		return false
+	}
+	for _, code := range codes {
+		if code == sqlErr.Number {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry: 按重試策略執行單條語句。交易中、ctx 已結束或錯誤不可重試時直接返回。
+func (p *NyaMySQL) withRetry(ctx context.Context, write bool, fn func() error) error {
+	if p.tx != nil {
+		return fn()
+	}
+	retryable := p.retry.Retryable
+	if retryable == nil {
+		retryable = IsTransientError
+	}
+	return p.retryLoop(ctx, func(err error) bool {
+		if write && (errors.Is(err, mysql.ErrInvalidConn) || isMySQLError(err, errConnLostCode)) {
+			return false
+		}
+		return retryable(err)
+	}, fn)
+}
+
+// retryLoop: 按重試策略反覆執行 fn ，直到成功、錯誤不可重試、達到次數上限或 ctx 結束
+func (p *NyaMySQL) retryLoop(ctx context.Context, retryable func(err error) bool, fn func() error) error {
+	policy := p.retry
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		wait := backoff
+		if policy.Jitter > 0 {
+			wait += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(backoff))
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, wait)
+		}
+		if p.loggerLevel == NYAMYSQL_LOG_LEVEL_DEBUG && p.debug != nil {
+			p.debug.Printf("retry %d/%d after %v, error:[%v]", attempt, policy.MaxAttempts-1, wait, err.Error())
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if policy.Multiplier > 1 {
+			backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		}
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
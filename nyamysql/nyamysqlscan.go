@@ -0,0 +1,288 @@
+// MySQL 查詢結果對映到結構體
+package nyamysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ColumnMappingError 表示查詢結果的列與結構體欄位無法一一對應。
+//
+//   - Type: 目標結構體型別名稱。
+//   - Unknown: 查詢結果中存在，但結構體中沒有對應欄位的列。
+//   - Missing: 結構體中需要，但查詢結果中不存在的列（標記了 optional 的欄位除外）。
+type ColumnMappingError struct {
+	Type    string
+	Unknown []string
+	Missing []string
+}
+
+func (e *ColumnMappingError) Error() string {
+	msg := "nyamysql: cannot map columns to " + e.Type
+	if len(e.Unknown) > 0 {
+		msg += fmt.Sprintf(", unknown columns %v", e.Unknown)
+	}
+	if len(e.Missing) > 0 {
+		msg += fmt.Sprintf(", missing columns %v", e.Missing)
+	}
+	return msg
+}
+
+// QueryInto 執行查詢並將每一行結果掃描為 T ，按查詢結果的順序返回。
+//
+// T 為結構體時，列按欄位的 `db:"col"` 標籤對應，沒有標籤的匯出欄位按欄位名（不區分大小寫）對應，
+// `db:"-"` 表示忽略該欄位，`db:"col,optional"` 表示該列可以不在查詢結果中。
+// 匿名嵌入的結構體欄位會被展開。查詢結果中有無法對應的列，或缺少必需的列時返回 *ColumnMappingError 。
+//
+// T 不是結構體（例如 int64、string、time.Time）時，查詢結果必須只有一列。
+//
+// 欄位可以使用 sql.Null* 、指標型別（NULL 對應 nil）、time.Time 與 []byte 。
+// 連線未啟用 parseTime 時，DATETIME/DATE/TIMESTAMP 的文字值也會被解析為 time.Time 。
+//
+// 引數:
+//   - q: NyaMySQL 或 Tx 。
+//   - dbq: 完整的SQL查詢語句。
+//   - values: 查詢條件的值。
+//
+// 返回值:
+//   - []T: 查詢結果，沒有資料時返回空切片。
+//   - error: 查詢或掃描失敗時返回錯誤。
+func QueryInto[T any](q Querier, dbq string, values ...interface{}) ([]T, error) {
+	return QueryIntoContext[T](context.Background(), q, dbq, values...)
+}
+
+// QueryIntoContext 同 QueryInto ，可透過 ctx 取消查詢或設定逾時。
+func QueryIntoContext[T any](ctx context.Context, q Querier, dbq string, values ...interface{}) ([]T, error) {
+	rows, err := q.QueryRowsContext(ctx, dbq, values...)
+	if err != nil {
+		return nil, err
+	}
+	return ScanRows[T](rows)
+}
+
+// QueryOne 執行查詢並返回第一行結果，沒有資料時返回 sql.ErrNoRows 。
+// 對映規則同 QueryInto 。
+func QueryOne[T any](q Querier, dbq string, values ...interface{}) (T, error) {
+	return QueryOneContext[T](context.Background(), q, dbq, values...)
+}
+
+// QueryOneContext 同 QueryOne ，可透過 ctx 取消查詢或設定逾時。
+func QueryOneContext[T any](ctx context.Context, q Querier, dbq string, values ...interface{}) (T, error) {
+	var zero T
+	rows, err := q.QueryRowsContext(ctx, dbq, values...)
+	if err != nil {
+		return zero, err
+	}
+	defer rows.Close()
+	scan, err := newRowScanner[T](rows)
+	if err != nil {
+		return zero, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return zero, err
+		}
+		return zero, sql.ErrNoRows
+	}
+	return scan(rows)
+}
+
+// ScanRows 將結果集中的所有行掃描為 T 並關閉結果集。對映規則同 QueryInto 。
+func ScanRows[T any](rows *sql.Rows) ([]T, error) {
+	defer rows.Close()
+	scan, err := newRowScanner[T](rows)
+	if err != nil {
+		return nil, err
+	}
+	results := []T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// newRowScanner: 根據結果集的列建立把目前行掃描為 T 的函式
+func newRowScanner[T any](rows *sql.Rows) (func(rows *sql.Rows) (T, error), error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+
+	// 非結構體型別（以及 time.Time）直接掃描唯一的一列
+	if typ.Kind() != reflect.Struct || typ == timeType {
+		if len(cols) != 1 {
+			return nil, fmt.Errorf("nyamysql: scanning into %s requires exactly one column, got %d", typ, len(cols))
+		}
+		return func(rows *sql.Rows) (T, error) {
+			var v T
+			err := rows.Scan(scanDest(reflect.ValueOf(&v).Elem()))
+			return v, err
+		}, nil
+	}
+
+	fields := structFields(typ)
+	indexes := make([][]int, len(cols))
+	used := map[string]bool{}
+	mapErr := &ColumnMappingError{Type: typ.String()}
+	for i, col := range cols {
+		f, ok := fields[strings.ToLower(col)]
+		if !ok {
+			mapErr.Unknown = append(mapErr.Unknown, col)
+			continue
+		}
+		indexes[i] = f.index
+		used[strings.ToLower(col)] = true
+	}
+	for name, f := range fields {
+		if !f.optional && !used[name] {
+			mapErr.Missing = append(mapErr.Missing, f.column)
+		}
+	}
+	if len(mapErr.Unknown) > 0 || len(mapErr.Missing) > 0 {
+		sort.Strings(mapErr.Missing)
+		return nil, mapErr
+	}
+
+	return func(rows *sql.Rows) (T, error) {
+		var v T
+		rv := reflect.ValueOf(&v).Elem()
+		dests := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			dests[i] = scanDest(rv.FieldByIndex(index))
+		}
+		err := rows.Scan(dests...)
+		return v, err
+	}, nil
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	fieldCache     sync.Map // reflect.Type -> map[string]fieldInfo
+	timeLayoutList = []string{
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05.999999999Z07:00",
+		"2006-01-02",
+		"15:04:05",
+	}
+)
+
+// fieldInfo: 結構體欄位與列的對應資訊
+type fieldInfo struct {
+	column   string
+	index    []int
+	optional bool
+}
+
+// structFields: 解析結構體的欄位對映，鍵為小寫的列名
+func structFields(typ reflect.Type) map[string]fieldInfo {
+	if cached, ok := fieldCache.Load(typ); ok {
+		return cached.(map[string]fieldInfo)
+	}
+	fields := map[string]fieldInfo{}
+	collectFields(typ, nil, fields)
+	fieldCache.Store(typ, fields)
+	return fields
+}
+
+// collectFields: 遞迴收集欄位，展開匿名嵌入的結構體
+func collectFields(typ reflect.Type, parent []int, fields map[string]fieldInfo) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			collectFields(f.Type, index, fields)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		key := strings.ToLower(name)
+		if existing, exists := fields[key]; exists && len(existing.index) <= len(index) {
+			continue // 外層欄位優先於嵌入結構體中的同名欄位，與宣告順序無關
+		}
+		fields[key] = fieldInfo{column: name, index: index, optional: opts == "optional"}
+	}
+}
+
+// scanDest: 返回可傳給 Scan 的目標，time.Time 欄位使用可解析文字的掃描器
+func scanDest(v reflect.Value) interface{} {
+	switch {
+	case v.Type() == timeType:
+		return &timeScanner{dst: v}
+	case v.Kind() == reflect.Pointer && v.Type().Elem() == timeType:
+		return &timeScanner{dst: v, ptr: true}
+	}
+	return v.Addr().Interface()
+}
+
+// timeScanner: 將 time.Time 或文字格式的時間掃描到 time.Time / *time.Time 欄位
+type timeScanner struct {
+	dst reflect.Value
+	ptr bool
+}
+
+func (s *timeScanner) Scan(src interface{}) error {
+	var t time.Time
+	switch v := src.(type) {
+	case nil:
+		s.dst.Set(reflect.Zero(s.dst.Type()))
+		return nil
+	case time.Time:
+		t = v
+	case []byte:
+		parsed, err := parseTime(string(v))
+		if err != nil {
+			return err
+		}
+		t = parsed
+	case string:
+		parsed, err := parseTime(v)
+		if err != nil {
+			return err
+		}
+		t = parsed
+	default:
+		return fmt.Errorf("nyamysql: cannot scan %T into time.Time", src)
+	}
+	if s.ptr {
+		s.dst.Set(reflect.ValueOf(&t))
+	} else {
+		s.dst.Set(reflect.ValueOf(t))
+	}
+	return nil
+}
+
+// parseTime: 按 MySQL 的常見格式解析時間文字，零值日期返回 time.Time{}
+func parseTime(str string) (time.Time, error) {
+	if strings.HasPrefix(str, "0000-00-00") {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayoutList {
+		if t, err := time.ParseInLocation(layout, str, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("nyamysql: cannot parse %q as time", str)
+}
@@ -0,0 +1,474 @@
+// MySQL 完整表結構（索引、外鍵、註解）
+package nyamysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TableSchema 是一個表的完整結構，由 GetTableSchema 讀取，可以用 CreateTableFromSchema 重建。
+//
+//   - Name: 表名。
+//   - Engine: 儲存引擎，例如 InnoDB 。
+//   - Charset: 表的預設字元集。
+//   - Collation: 表的預設排序規則。
+//   - Comment: 表註解。
+//   - Columns: 按定義順序排列的列。
+//   - Indexes: 索引，主鍵（如果有）排在第一個。
+//   - ForeignKeys: 外鍵約束。
+type TableSchema struct {
+	Name        string
+	Engine      string
+	Charset     string
+	Collation   string
+	Comment     string
+	Columns     []SchemaColumn
+	Indexes     []TableIndex
+	ForeignKeys []ForeignKey
+}
+
+// SchemaColumn 是 TableColumn 加上字元集、排序規則、註解和生成列表達式。
+// 非字串類型的列 CharacterSet 和 Collation 為空。
+type SchemaColumn struct {
+	TableColumn
+	CharacterSet         string
+	Collation            string
+	Comment              string
+	GenerationExpression string
+}
+
+// TableIndex 是一個索引。
+//
+//   - Name: 索引名，主鍵為 PRIMARY 。
+//   - Unique: 是否為唯一索引（包括主鍵）。
+//   - Type: 索引類型，例如 BTREE 、FULLTEXT 、SPATIAL 、HASH 。
+//   - Columns: 按順序排列的索引列。
+//   - Comment: 索引註解。
+type TableIndex struct {
+	Name    string
+	Unique  bool
+	Type    string
+	Columns []IndexColumn
+	Comment string
+}
+
+// IndexColumn 是索引中的一列或一個表達式。
+//
+//   - Name: 列名，函數索引的表達式部分為空。
+//   - SubPart: 前綴索引的長度，0 表示整列。
+//   - Desc: 是否為降序索引（MySQL 8.0 以上）。
+//   - Expression: 函數索引（MySQL 8.0.13 以上）的表達式，例如 abs(`a`) ，普通列為空。
+type IndexColumn struct {
+	Name       string
+	SubPart    int
+	Desc       bool
+	Expression string
+}
+
+// ForeignKey 是一個外鍵約束。
+//
+//   - Name: 約束名。
+//   - Columns: 本表的列。
+//   - RefTable: 參照的表。
+//   - RefColumns: 參照的列，與 Columns 一一對應。
+//   - OnUpdate: 更新時的動作，例如 RESTRICT 、CASCADE 、SET NULL 、NO ACTION 。
+//   - OnDelete: 刪除時的動作。
+type ForeignKey struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnUpdate   string
+	OnDelete   string
+}
+
+// IsPrimary 判斷索引是否為主鍵。
+func (idx TableIndex) IsPrimary() bool {
+	return idx.Name == "PRIMARY"
+}
+
+// Column 按列名查詢列，找不到時返回 false 。
+func (s TableSchema) Column(name string) (SchemaColumn, bool) {
+	for _, col := range s.Columns {
+		if strings.EqualFold(col.ColumnName, name) {
+			return col, true
+		}
+	}
+	return SchemaColumn{}, false
+}
+
+// GetTableSchema 讀取表的完整結構，包括列註解、索引、外鍵、引擎、字元集和表註解。
+//
+// 引數:
+//   - tableName: 目前資料庫中的表名。
+//
+// 返回值:
+//   - *TableSchema: 表結構。
+//   - error: 查詢失敗時返回錯誤，表不存在時返回的錯誤可以用 errors.Is(err, sql.ErrNoRows) 判斷。
+func (p *NyaMySQL) GetTableSchema(tableName string) (*TableSchema, error) {
+	return p.GetTableSchemaContext(context.Background(), tableName)
+}
+
+// GetTableSchemaContext 同 GetTableSchema ，可透過 ctx 取消查詢或設定逾時
+func (p *NyaMySQL) GetTableSchemaContext(ctx context.Context, tableName string) (*TableSchema, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	schema := &TableSchema{Name: tableName}
+	if err := p.loadTableInfo(ctx, schema); err != nil {
+		return nil, err
+	}
+	if err := p.loadSchemaColumns(ctx, schema); err != nil {
+		return nil, err
+	}
+	if err := p.loadIndexes(ctx, schema); err != nil {
+		return nil, err
+	}
+	if err := p.loadForeignKeys(ctx, schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// ListTables 返回目前資料庫中所有基本表（不包括檢視）的表名。
+func (p *NyaMySQL) ListTables() ([]string, error) {
+	return p.ListTablesContext(context.Background())
+}
+
+// ListTablesContext 同 ListTables ，可透過 ctx 取消查詢或設定逾時
+func (p *NyaMySQL) ListTablesContext(ctx context.Context) ([]string, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	dbq := "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"
+	rows, err := p.queryContext(ctx, "ListTables", dbq, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// CreateTableFromSchema 根據 GetTableSchema 讀取的結構建立表，語句由 TableSchema.CreateTableSQL 生成。
+//
+// 引數:
+//   - schema: 表結構，表名使用 schema.Name 。
+//
+// 返回值:
+//   - error: 建立失敗時返回錯誤。
+func (p *NyaMySQL) CreateTableFromSchema(schema *TableSchema) error {
+	return p.CreateTableFromSchemaContext(context.Background(), schema)
+}
+
+// CreateTableFromSchemaContext 同 CreateTableFromSchema ，可透過 ctx 取消執行或設定逾時
+func (p *NyaMySQL) CreateTableFromSchemaContext(ctx context.Context, schema *TableSchema) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	_, err := p.execContext(ctx, "CreateTableFromSchema", schema.CreateTableSQL(), nil)
+	return err
+}
+
+// CreateTableSQL 生成重建此表的 CREATE TABLE 語句（不含結尾分號）。
+// 列的字元集和排序規則只在與表的預設值不同時輸出。
+func (s TableSchema) CreateTableSQL() string {
+	defs := make([]string, 0, len(s.Columns)+len(s.Indexes)+len(s.ForeignKeys))
+	for _, col := range s.Columns {
+		defs = append(defs, columnDefinition(col, s))
+	}
+	for _, idx := range s.Indexes {
+		defs = append(defs, indexDefinition(idx))
+	}
+	for _, fk := range s.ForeignKeys {
+		defs = append(defs, foreignKeyDefinition(fk))
+	}
+	var b strings.Builder
+	b.WriteString("CREATE TABLE " + quoteIdent(s.Name) + " (\n  ")
+	b.WriteString(strings.Join(defs, ",\n  "))
+	b.WriteString("\n)")
+	b.WriteString(tableOptions(s))
+	return b.String()
+}
+
+// loadTableInfo: 讀取引擎、字元集、排序規則和表註解
+func (p *NyaMySQL) loadTableInfo(ctx context.Context, schema *TableSchema) error {
+	dbq := `
+		SELECT IFNULL(t.ENGINE, ''), IFNULL(t.TABLE_COLLATION, ''), IFNULL(c.CHARACTER_SET_NAME, ''), IFNULL(t.TABLE_COMMENT, '')
+		FROM INFORMATION_SCHEMA.TABLES t
+		LEFT JOIN INFORMATION_SCHEMA.COLLATION_CHARACTER_SET_APPLICABILITY c ON c.COLLATION_NAME = t.TABLE_COLLATION
+		WHERE t.TABLE_SCHEMA = DATABASE() AND t.TABLE_NAME = ?`
+	rows, err := p.queryContext(ctx, "GetTableSchema", dbq, []interface{}{schema.Name})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("nyamysql: table %q: %w", schema.Name, sql.ErrNoRows)
+	}
+	if err := rows.Scan(&schema.Engine, &schema.Collation, &schema.Charset, &schema.Comment); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// loadSchemaColumns: 讀取所有列
+func (p *NyaMySQL) loadSchemaColumns(ctx context.Context, schema *TableSchema) error {
+	dbq := `
+		SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, COLUMN_DEFAULT, EXTRA,
+			IFNULL(CHARACTER_SET_NAME, ''), IFNULL(COLLATION_NAME, ''), COLUMN_COMMENT, IFNULL(GENERATION_EXPRESSION, '')
+		FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION`
+	rows, err := p.queryContext(ctx, "GetTableSchema", dbq, []interface{}{schema.Name})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var col SchemaColumn
+		err := rows.Scan(&col.ColumnName, &col.ColumnType, &col.IsNullable, &col.ColumnKey, &col.ColumnDefault, &col.Extra,
+			&col.CharacterSet, &col.Collation, &col.Comment, &col.GenerationExpression)
+		if err != nil {
+			return err
+		}
+		schema.Columns = append(schema.Columns, col)
+	}
+	return rows.Err()
+}
+
+// 讀取索引的語句，%s 為函數索引的表達式欄位
+const loadIndexesSQL = `
+		SELECT INDEX_NAME, NON_UNIQUE, IFNULL(COLUMN_NAME, ''), IFNULL(SUB_PART, 0), INDEX_TYPE, IFNULL(INDEX_COMMENT, ''), IFNULL(COLLATION, 'A'), %s
+		FROM INFORMATION_SCHEMA.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		ORDER BY INDEX_NAME <> 'PRIMARY', INDEX_NAME, SEQ_IN_INDEX`
+
+// loadIndexes: 從 STATISTICS 讀取索引（包括函數索引），主鍵排在第一個，其他索引按名稱排序。
+// 8.0.13 以前的 MySQL 和 MariaDB 沒有 EXPRESSION 欄位，也不支援函數索引，此時改用空字串。
+func (p *NyaMySQL) loadIndexes(ctx context.Context, schema *TableSchema) error {
+	rows, err := p.queryContext(ctx, "GetTableSchema", fmt.Sprintf(loadIndexesSQL, "IFNULL(EXPRESSION, '')"), []interface{}{schema.Name})
+	if isMySQLError(err, []uint16{1054}) {
+		rows, err = p.queryContext(ctx, "GetTableSchema", fmt.Sprintf(loadIndexesSQL, "''"), []interface{}{schema.Name})
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name, indexType, comment, collation string
+			nonUnique                           int
+			col                                 IndexColumn
+		)
+		if err := rows.Scan(&name, &nonUnique, &col.Name, &col.SubPart, &indexType, &comment, &collation, &col.Expression); err != nil {
+			return err
+		}
+		col.Desc = collation == "D"
+		n := len(schema.Indexes)
+		if n == 0 || schema.Indexes[n-1].Name != name {
+			schema.Indexes = append(schema.Indexes, TableIndex{Name: name, Unique: nonUnique == 0, Type: indexType, Comment: comment})
+			n++
+		}
+		schema.Indexes[n-1].Columns = append(schema.Indexes[n-1].Columns, col)
+	}
+	return rows.Err()
+}
+
+// loadForeignKeys: 從 KEY_COLUMN_USAGE 和 REFERENTIAL_CONSTRAINTS 讀取外鍵
+func (p *NyaMySQL) loadForeignKeys(ctx context.Context, schema *TableSchema) error {
+	dbq := `
+		SELECT k.CONSTRAINT_NAME, k.COLUMN_NAME, k.REFERENCED_TABLE_NAME, k.REFERENCED_COLUMN_NAME, r.UPDATE_RULE, r.DELETE_RULE
+		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE k
+		JOIN INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS r
+			ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND r.TABLE_NAME = k.TABLE_NAME AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME
+		WHERE k.TABLE_SCHEMA = DATABASE() AND k.TABLE_NAME = ? AND k.REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY k.CONSTRAINT_NAME, k.ORDINAL_POSITION`
+	rows, err := p.queryContext(ctx, "GetTableSchema", dbq, []interface{}{schema.Name})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, column, refTable, refColumn, onUpdate, onDelete string
+		if err := rows.Scan(&name, &column, &refTable, &refColumn, &onUpdate, &onDelete); err != nil {
+			return err
+		}
+		n := len(schema.ForeignKeys)
+		if n == 0 || schema.ForeignKeys[n-1].Name != name {
+			schema.ForeignKeys = append(schema.ForeignKeys, ForeignKey{Name: name, RefTable: refTable, OnUpdate: onUpdate, OnDelete: onDelete})
+			n++
+		}
+		fk := &schema.ForeignKeys[n-1]
+		fk.Columns = append(fk.Columns, column)
+		fk.RefColumns = append(fk.RefColumns, refColumn)
+	}
+	return rows.Err()
+}
+
+// 可以不加括號直接作為預設值的時間函式，例如 CURRENT_TIMESTAMP(3)
+var currentTimestampRe = regexp.MustCompile(`(?i)^(current_timestamp|now|localtime|localtimestamp)(\(\d*\))?$`)
+
+// columnDefinition: 生成列定義，例如 `name` varchar(32) NOT NULL DEFAULT 'a' COMMENT '名稱'
+func columnDefinition(col SchemaColumn, table TableSchema) string {
+	parts := []string{quoteIdent(col.ColumnName), col.ColumnType}
+	if col.CharacterSet != "" && col.CharacterSet != table.Charset {
+		parts = append(parts, "CHARACTER SET "+col.CharacterSet)
+	}
+	if col.Collation != "" && col.Collation != table.Collation {
+		parts = append(parts, "COLLATE "+col.Collation)
+	}
+
+	extra := strings.TrimSpace(strings.Replace(col.Extra, "DEFAULT_GENERATED", "", 1))
+	lowerExtra := strings.ToLower(extra)
+	if col.GenerationExpression != "" && strings.Contains(lowerExtra, "generated") {
+		kind := "VIRTUAL"
+		if strings.Contains(lowerExtra, "stored") {
+			kind = "STORED"
+		}
+		parts = append(parts, "GENERATED ALWAYS AS ("+col.GenerationExpression+") "+kind)
+		extra = ""
+	}
+
+	if col.IsNullable == "NO" {
+		parts = append(parts, "NOT NULL")
+	}
+	if def := columnDefaultSQL(col); def != "" {
+		parts = append(parts, "DEFAULT "+def)
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if col.Comment != "" {
+		parts = append(parts, "COMMENT "+quoteString(col.Comment))
+	}
+	return strings.Join(parts, " ")
+}
+
+// columnDefaultSQL: 生成預設值部分。時間函式原樣輸出，MySQL 8.0 的表達式預設值加括號，
+// 數值型別的數字和位元值原樣輸出，其餘按字串加引號。沒有預設值時返回空字串。
+func columnDefaultSQL(col SchemaColumn) string {
+	if !col.ColumnDefault.Valid {
+		return ""
+	}
+	def := col.ColumnDefault.String
+	if currentTimestampRe.MatchString(def) {
+		return def
+	}
+	if strings.Contains(col.Extra, "DEFAULT_GENERATED") {
+		return "(" + def + ")"
+	}
+	colType := strings.ToLower(col.ColumnType)
+	if strings.HasPrefix(colType, "bit") && strings.HasPrefix(def, "b'") {
+		return def
+	}
+	for _, numeric := range []string{"tinyint", "smallint", "mediumint", "int", "bigint", "decimal", "numeric", "float", "double", "real"} {
+		if strings.HasPrefix(colType, numeric) {
+			if _, err := strconv.ParseFloat(def, 64); err == nil {
+				return def
+			}
+			break
+		}
+	}
+	return quoteString(def)
+}
+
+// indexDefinition: 生成 CREATE TABLE 中的索引定義，例如 UNIQUE KEY `uk_name` (`name`(16)) 、KEY `idx_abs` ((abs(`a`)))
+func indexDefinition(idx TableIndex) string {
+	cols := make([]string, len(idx.Columns))
+	for i, c := range idx.Columns {
+		if c.Expression != "" {
+			cols[i] = "(" + c.Expression + ")"
+		} else {
+			cols[i] = quoteIdent(c.Name)
+		}
+		if c.SubPart > 0 {
+			cols[i] += "(" + strconv.Itoa(c.SubPart) + ")"
+		}
+		if c.Desc {
+			cols[i] += " DESC"
+		}
+	}
+	var def string
+	switch {
+	case idx.IsPrimary():
+		def = "PRIMARY KEY"
+	case idx.Unique:
+		def = "UNIQUE KEY " + quoteIdent(idx.Name)
+	case strings.EqualFold(idx.Type, "FULLTEXT"):
+		def = "FULLTEXT KEY " + quoteIdent(idx.Name)
+	case strings.EqualFold(idx.Type, "SPATIAL"):
+		def = "SPATIAL KEY " + quoteIdent(idx.Name)
+	default:
+		def = "KEY " + quoteIdent(idx.Name)
+	}
+	def += " (" + strings.Join(cols, ",") + ")"
+	if idx.Comment != "" {
+		def += " COMMENT " + quoteString(idx.Comment)
+	}
+	return def
+}
+
+// foreignKeyDefinition: 生成外鍵約束定義
+func foreignKeyDefinition(fk ForeignKey) string {
+	def := "CONSTRAINT " + quoteIdent(fk.Name) + " FOREIGN KEY (" + quoteIdents(fk.Columns) + ") REFERENCES " +
+		quoteIdent(fk.RefTable) + " (" + quoteIdents(fk.RefColumns) + ")"
+	if fk.OnDelete != "" && fk.OnDelete != "RESTRICT" && fk.OnDelete != "NO ACTION" {
+		def += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" && fk.OnUpdate != "RESTRICT" && fk.OnUpdate != "NO ACTION" {
+		def += " ON UPDATE " + fk.OnUpdate
+	}
+	return def
+}
+
+// tableOptions: 生成表選項，例如 ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
+func tableOptions(s TableSchema) string {
+	var opts string
+	if s.Engine != "" {
+		opts += " ENGINE=" + s.Engine
+	}
+	if s.Charset != "" {
+		opts += " DEFAULT CHARSET=" + s.Charset
+	}
+	if s.Collation != "" {
+		opts += " COLLATE=" + s.Collation
+	}
+	if s.Comment != "" {
+		opts += " COMMENT=" + quoteString(s.Comment)
+	}
+	return opts
+}
+
+// quoteIdent: 以反引號包裹識別符號
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteIdents: 以反引號包裹多個識別符號並以逗號連接
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteIdent(n)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// quoteString: 生成 SQL 字串常量
+func quoteString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(s) + "'"
+}
@@ -0,0 +1,372 @@
+// MySQL 表結構比較
+package nyamysql
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// SchemaChange 是使目前結構與目標結構一致所需的一條語句。
+//
+//   - Table: 語句作用的表。
+//   - SQL: 要執行的語句（不含結尾分號）。
+//   - Description: 變更說明，例如 "drop column users.age" 。
+//   - Destructive: 是否可能丟失資料（刪除表、刪除列、修改列型別或字元集）。
+type SchemaChange struct {
+	Table       string
+	SQL         string
+	Description string
+	Destructive bool
+}
+
+// SchemaDiff 是兩個資料庫結構的差異，Changes 已按可以安全依次執行的順序排列：
+// 刪除外鍵、建立新表、修改列（包括以 FIRST / AFTER 調整列順序）和索引、修改表選項、新增外鍵、刪除表。
+type SchemaDiff struct {
+	Changes []SchemaChange
+}
+
+// Empty 判斷兩個結構是否一致。
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// Statements 按順序返回所有語句。
+func (d *SchemaDiff) Statements() []string {
+	stmts := make([]string, len(d.Changes))
+	for i, c := range d.Changes {
+		stmts[i] = c.SQL
+	}
+	return stmts
+}
+
+// Safe 返回不會丟失資料的變更，保持原順序。
+func (d *SchemaDiff) Safe() []SchemaChange {
+	return d.filter(false)
+}
+
+// Destructive 返回可能丟失資料的變更，保持原順序。
+func (d *SchemaDiff) Destructive() []SchemaChange {
+	return d.filter(true)
+}
+
+func (d *SchemaDiff) filter(destructive bool) []SchemaChange {
+	changes := []SchemaChange{}
+	for _, c := range d.Changes {
+		if c.Destructive == destructive {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// GetDatabaseSchema 讀取目前資料庫中所有基本表的完整結構，按表名排序。
+func (p *NyaMySQL) GetDatabaseSchema() ([]TableSchema, error) {
+	return p.GetDatabaseSchemaContext(context.Background())
+}
+
+// GetDatabaseSchemaContext 同 GetDatabaseSchema ，可透過 ctx 取消查詢或設定逾時
+func (p *NyaMySQL) GetDatabaseSchemaContext(ctx context.Context) ([]TableSchema, error) {
+	tables, err := p.ListTablesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make([]TableSchema, 0, len(tables))
+	for _, table := range tables {
+		schema, err := p.GetTableSchemaContext(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, *schema)
+	}
+	return schemas, nil
+}
+
+// DiffSchema 比較兩個資料庫的結構，返回使 current 與 target 一致所需的語句。
+// 例如部署前確認預備環境與正式環境的結構一致：DiffSchema(ctx, staging, production) 。
+//
+// 引數:
+//   - ctx: 上下文。
+//   - current: 要被修改的資料庫。
+//   - target: 作為目標的資料庫。
+//
+// 返回值:
+//   - *SchemaDiff: 結構差異，沒有差異時 Empty() 為 true 。
+//   - error: 讀取任一資料庫結構失敗時返回錯誤。
+func DiffSchema(ctx context.Context, current *NyaMySQL, target *NyaMySQL) (*SchemaDiff, error) {
+	from, err := current.GetDatabaseSchemaContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	to, err := target.GetDatabaseSchemaContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return DiffTableSchemas(from, to), nil
+}
+
+// DiffTableSchemas 同 DiffSchema ，但比較已讀取或自行宣告的結構，不連線資料庫。
+// 只出現在 target 中的表會被建立，只出現在 current 中的表會被刪除。
+func DiffTableSchemas(current []TableSchema, target []TableSchema) *SchemaDiff {
+	cur := tablesByName(current)
+	tgt := tablesByName(target)
+	names := make([]string, 0, len(cur)+len(tgt))
+	for name := range cur {
+		names = append(names, name)
+	}
+	for name := range tgt {
+		if _, ok := cur[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var dropFKs, creates, alters, addFKs, drops []SchemaChange
+	for _, name := range names {
+		from, inCur := cur[name]
+		to, inTgt := tgt[name]
+		switch {
+		case !inCur:
+			table := to
+			table.ForeignKeys = nil
+			creates = append(creates, SchemaChange{Table: name, SQL: table.CreateTableSQL(), Description: "create table " + name})
+			addFKs = append(addFKs, addForeignKeys(name, nil, to.ForeignKeys)...)
+		case !inTgt:
+			dropFKs = append(dropFKs, dropForeignKeys(name, from.ForeignKeys, nil)...)
+			drops = append(drops, SchemaChange{Table: name, SQL: "DROP TABLE " + quoteIdent(name), Description: "drop table " + name, Destructive: true})
+		default:
+			dropFKs = append(dropFKs, dropForeignKeys(name, from.ForeignKeys, to.ForeignKeys)...)
+			alters = append(alters, diffTable(from, to)...)
+			addFKs = append(addFKs, addForeignKeys(name, from.ForeignKeys, to.ForeignKeys)...)
+		}
+	}
+
+	diff := &SchemaDiff{Changes: []SchemaChange{}}
+	for _, group := range [][]SchemaChange{dropFKs, creates, alters, addFKs, drops} {
+		diff.Changes = append(diff.Changes, group...)
+	}
+	return diff
+}
+
+// diffTable: 比較同一個表的列、索引和表選項
+func diffTable(from TableSchema, to TableSchema) []SchemaChange {
+	name := to.Name
+	alter := "ALTER TABLE " + quoteIdent(name) + " "
+	var adds, modifies, dropIdx, addIdx, dropCols, options []SchemaChange
+
+	// 列：兩邊都以目標表的字元集為基準生成定義後比較。
+	// order 記錄執行到目前為止的語句後表中實際的列順序，用於判斷哪些列需要以 FIRST / AFTER 移動。
+	order := make([]string, 0, len(from.Columns)+len(to.Columns))
+	for _, col := range from.Columns {
+		order = append(order, col.ColumnName)
+	}
+	for i, col := range to.Columns {
+		if _, ok := from.Column(col.ColumnName); ok {
+			continue
+		}
+		after := previousName(to.Columns, i)
+		adds = append(adds, SchemaChange{Table: name, SQL: alter + "ADD COLUMN " + columnDefinition(col, to) + columnPosition(after), Description: "add column " + name + "." + col.ColumnName})
+		order = moveColumn(order, col.ColumnName, after)
+	}
+	// 按目標順序修改定義不同或位置不對的列，比較位置時忽略將被刪除的列
+	for i, col := range to.Columns {
+		def := columnDefinition(col, to)
+		after := previousName(to.Columns, i)
+		moved := !strings.EqualFold(previousColumn(order, col.ColumnName, to), after)
+		old, existed := from.Column(col.ColumnName)
+		changed := existed && columnDefinition(old, to) != def
+		if !moved && !changed {
+			continue
+		}
+		c := SchemaChange{Table: name, SQL: alter + "MODIFY COLUMN " + def, Description: "modify column " + name + "." + col.ColumnName}
+		if changed {
+			c.Destructive = !strings.EqualFold(old.ColumnType, col.ColumnType) ||
+				(old.CharacterSet != "" && old.CharacterSet != col.CharacterSet) ||
+				(old.IsNullable == "YES" && col.IsNullable == "NO")
+		} else {
+			c.Description = "move column " + name + "." + col.ColumnName
+		}
+		if moved {
+			c.SQL += columnPosition(after)
+			order = moveColumn(order, col.ColumnName, after)
+		}
+		modifies = append(modifies, c)
+	}
+	for _, col := range from.Columns {
+		if _, ok := to.Column(col.ColumnName); !ok {
+			dropCols = append(dropCols, SchemaChange{Table: name, SQL: alter + "DROP COLUMN " + quoteIdent(col.ColumnName), Description: "drop column " + name + "." + col.ColumnName, Destructive: true})
+		}
+	}
+
+	// 索引：定義不同時先刪除再建立
+	oldIdx := map[string]TableIndex{}
+	for _, idx := range from.Indexes {
+		oldIdx[idx.Name] = idx
+	}
+	newIdx := map[string]TableIndex{}
+	for _, idx := range to.Indexes {
+		newIdx[idx.Name] = idx
+	}
+	for _, idx := range from.Indexes {
+		if n, ok := newIdx[idx.Name]; !ok || indexDefinition(n) != indexDefinition(idx) {
+			dropIdx = append(dropIdx, dropIndex(name, idx))
+		}
+	}
+	for _, idx := range to.Indexes {
+		if o, ok := oldIdx[idx.Name]; !ok || indexDefinition(o) != indexDefinition(idx) {
+			addIdx = append(addIdx, createIndex(name, idx))
+		}
+	}
+
+	// 表選項
+	var opts []string
+	if to.Engine != "" && !strings.EqualFold(from.Engine, to.Engine) {
+		opts = append(opts, "ENGINE="+to.Engine)
+	}
+	if to.Charset != "" && (from.Charset != to.Charset || from.Collation != to.Collation) {
+		opts = append(opts, "DEFAULT CHARSET="+to.Charset)
+		if to.Collation != "" {
+			opts = append(opts, "COLLATE="+to.Collation)
+		}
+	}
+	if from.Comment != to.Comment {
+		opts = append(opts, "COMMENT="+quoteString(to.Comment))
+	}
+	if len(opts) > 0 {
+		options = append(options, SchemaChange{Table: name, SQL: alter + strings.Join(opts, " "), Description: "alter table options " + name})
+	}
+
+	changes := []SchemaChange{}
+	for _, group := range [][]SchemaChange{dropIdx, adds, modifies, addIdx, dropCols, options} {
+		changes = append(changes, group...)
+	}
+	return changes
+}
+
+// previousName: 返回第 i 列的前一列的列名，第一列返回空字串
+func previousName(columns []SchemaColumn, i int) string {
+	if i == 0 {
+		return ""
+	}
+	return columns[i-1].ColumnName
+}
+
+// columnPosition: 生成列位置子句，`after` 為空時為 FIRST
+func columnPosition(after string) string {
+	if after == "" {
+		return " FIRST"
+	}
+	return " AFTER " + quoteIdent(after)
+}
+
+// previousColumn: 返回 order 中 name 之前最近的一個也存在於 table 中的列，沒有時返回空字串
+func previousColumn(order []string, name string, table TableSchema) string {
+	i := indexOfColumn(order, name)
+	for i--; i >= 0; i-- {
+		if _, ok := table.Column(order[i]); ok {
+			return order[i]
+		}
+	}
+	return ""
+}
+
+// moveColumn: 將 name 移到 after 之後（after 為空時移到最前），name 不在 order 中時插入
+func moveColumn(order []string, name string, after string) []string {
+	if i := indexOfColumn(order, name); i >= 0 {
+		order = append(order[:i], order[i+1:]...)
+	}
+	pos := 0
+	if after != "" {
+		pos = indexOfColumn(order, after) + 1
+	}
+	order = append(order, "")
+	copy(order[pos+1:], order[pos:])
+	order[pos] = name
+	return order
+}
+
+// indexOfColumn: 不區分大小寫查詢列名的位置，找不到時返回 -1
+func indexOfColumn(order []string, name string) int {
+	for i, n := range order {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// dropIndex: 生成刪除索引的語句
+func dropIndex(table string, idx TableIndex) SchemaChange {
+	c := SchemaChange{Table: table, Description: "drop index " + table + "." + idx.Name}
+	if idx.IsPrimary() {
+		c.SQL = "ALTER TABLE " + quoteIdent(table) + " DROP PRIMARY KEY"
+	} else {
+		c.SQL = "DROP INDEX " + quoteIdent(idx.Name) + " ON " + quoteIdent(table)
+	}
+	return c
+}
+
+// createIndex: 生成建立索引的語句，主鍵使用 ALTER TABLE ADD PRIMARY KEY
+func createIndex(table string, idx TableIndex) SchemaChange {
+	c := SchemaChange{Table: table, Description: "create index " + table + "." + idx.Name}
+	def := indexDefinition(idx)
+	if idx.IsPrimary() {
+		c.SQL = "ALTER TABLE " + quoteIdent(table) + " ADD " + def
+		return c
+	}
+	// indexDefinition 返回 "[UNIQUE |FULLTEXT |SPATIAL ]KEY `name` (...)"
+	kind, rest, _ := strings.Cut(def, "KEY ")
+	name, cols, _ := strings.Cut(rest, " ")
+	c.SQL = "CREATE " + kind + "INDEX " + name + " ON " + quoteIdent(table) + " " + cols
+	return c
+}
+
+// dropForeignKeys: 生成刪除 from 中有而 to 中沒有或定義不同的外鍵的語句
+func dropForeignKeys(table string, from []ForeignKey, to []ForeignKey) []SchemaChange {
+	changes := []SchemaChange{}
+	for _, fk := range from {
+		if !containsForeignKey(to, fk) {
+			changes = append(changes, SchemaChange{
+				Table:       table,
+				SQL:         "ALTER TABLE " + quoteIdent(table) + " DROP FOREIGN KEY " + quoteIdent(fk.Name),
+				Description: "drop foreign key " + table + "." + fk.Name,
+			})
+		}
+	}
+	return changes
+}
+
+// addForeignKeys: 生成新增 to 中有而 from 中沒有或定義不同的外鍵的語句
+func addForeignKeys(table string, from []ForeignKey, to []ForeignKey) []SchemaChange {
+	changes := []SchemaChange{}
+	for _, fk := range to {
+		if !containsForeignKey(from, fk) {
+			changes = append(changes, SchemaChange{
+				Table:       table,
+				SQL:         "ALTER TABLE " + quoteIdent(table) + " ADD " + foreignKeyDefinition(fk),
+				Description: "add foreign key " + table + "." + fk.Name,
+			})
+		}
+	}
+	return changes
+}
+
+// containsForeignKey: 判斷 list 中是否有同名且定義相同的外鍵
+func containsForeignKey(list []ForeignKey, fk ForeignKey) bool {
+	def := foreignKeyDefinition(fk)
+	for _, f := range list {
+		if f.Name == fk.Name && foreignKeyDefinition(f) == def {
+			return true
+		}
+	}
+	return false
+}
+
+// tablesByName: 以表名為鍵建立索引
+func tablesByName(tables []TableSchema) map[string]TableSchema {
+	m := make(map[string]TableSchema, len(tables))
+	for _, t := range tables {
+		m[t.Name] = t
+	}
+	return m
+}
@@ -0,0 +1,259 @@
+// MySQL 軟刪除
+package nyamysql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 查詢時對已軟刪除行的處理方式
+const (
+	trashedExclude = iota // 排除已刪除的行（預設）
+	trashedWith           // 包括已刪除的行
+	trashedOnly           // 只查詢已刪除的行
+)
+
+// softDeleteSet: 表名 -> 刪除時間欄位，在 NyaMySQL 的副本之間共享
+type softDeleteSet struct {
+	mu     sync.RWMutex
+	tables map[string]string
+}
+
+// SetSoftDelete 為表設定軟刪除欄位（例如 deleted_at ，應為可以為 NULL 的時間型別）。
+// 設定後 DeleteRecord 、DeleteRecordNoPK 改為將該欄位設為目前時間，
+// QueryData 、QueryDataJOIN 、Paginate 自動排除該欄位不為 NULL 的行。
+// QueryTable 、FreequeryData 等執行完整語句的方法不受影響。
+//
+// 引數:
+//   - table: 表名，不需要反引號包裹。
+//   - column: 刪除時間欄位，為空時取消該表的軟刪除。
+func (p *NyaMySQL) SetSoftDelete(table string, column string) {
+	if p.softDeletes == nil {
+		return
+	}
+	p.softDeletes.mu.Lock()
+	defer p.softDeletes.mu.Unlock()
+	if column == "" {
+		delete(p.softDeletes.tables, table)
+		return
+	}
+	p.softDeletes.tables[table] = column
+}
+
+// WithTrashed 返回一個查詢時包括已軟刪除行的副本，與原實例共享連線。
+func (p *NyaMySQL) WithTrashed() *NyaMySQL {
+	np := *p
+	np.trashed = trashedWith
+	return &np
+}
+
+// OnlyTrashed 返回一個查詢時只返回已軟刪除行的副本，與原實例共享連線。
+// QueryDataJOIN 中只對第一個表生效，其他表仍排除已刪除的行。
+func (p *NyaMySQL) OnlyTrashed() *NyaMySQL {
+	np := *p
+	np.trashed = trashedOnly
+	return &np
+}
+
+// ForceDelete 返回一個刪除時真正刪除行（即使表設定了軟刪除）的副本，與原實例共享連線。
+func (p *NyaMySQL) ForceDelete() *NyaMySQL {
+	np := *p
+	np.forceDelete = true
+	return &np
+}
+
+// WithTrashed: 同 NyaMySQL.WithTrashed ，返回綁定同一交易的副本
+func (t *Tx) WithTrashed() *Tx {
+	return t.with(t.p.WithTrashed())
+}
+
+// OnlyTrashed: 同 NyaMySQL.OnlyTrashed ，返回綁定同一交易的副本
+func (t *Tx) OnlyTrashed() *Tx {
+	return t.with(t.p.OnlyTrashed())
+}
+
+// ForceDelete: 同 NyaMySQL.ForceDelete ，返回綁定同一交易的副本
+func (t *Tx) ForceDelete() *Tx {
+	return t.with(t.p.ForceDelete())
+}
+
+// with: 返回使用另一個 NyaMySQL 副本的同一交易
+func (t *Tx) with(p *NyaMySQL) *Tx {
+	nt := *t
+	nt.p = p
+	return &nt
+}
+
+// Restore: 恢復已軟刪除的行，即將刪除時間欄位設為 NULL
+//
+//	`table`		string		從哪個表中恢復，不需要``包裹，必須已設定軟刪除
+//	`key`		string		根據哪個關鍵字恢復
+//	`and`		string		附加條件，同 DeleteRecord
+//	`values`	...interface{}	條件的值，同 DeleteRecord
+//	return		int64		恢復的行数
+//	return		error		錯誤
+func (p *NyaMySQL) Restore(table string, key string, and string, values ...interface{}) (int64, error) {
+	return p.RestoreContext(context.Background(), table, key, and, values...)
+}
+
+// RestoreContext: 同 Restore ，可透過 `ctx` 取消操作或設定逾時
+//
+//	`ctx`	context.Context	上下文
+//	其餘引數與返回值同 Restore
+func (p *NyaMySQL) RestoreContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
+	column := p.softDeleteColumn(table)
+	if column == "" {
+		return 0, fmt.Errorf("nyamysql: table `%s` has no soft delete column", table)
+	}
+	where := "`" + key + "`=? " + and
+	if n := len(values) - strings.Count(and, "?"); n > 1 {
+		where = "`" + key + "` in (?" + strings.Repeat(",?", n-1) + ") " + and
+	}
+	dbq := fmt.Sprintf("update `%s` set `%s`=NULL where (%s) and `%s` is not null", table, column, where, column)
+	result, err := p.execContext(ctx, "Restore", dbq, values)
+	if err != nil {
+		return 0, err
+	}
+	num, _ := result.RowsAffected()
+	return num, nil
+}
+
+// Restore: 同 NyaMySQL.Restore ，在交易中執行
+func (t *Tx) Restore(table string, key string, and string, values ...interface{}) (int64, error) {
+	return t.p.Restore(table, key, and, values...)
+}
+
+// RestoreContext: 同 NyaMySQL.RestoreContext ，在交易中執行
+func (t *Tx) RestoreContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error) {
+	return t.p.RestoreContext(ctx, table, key, and, values...)
+}
+
+// softDeleteColumn: 返回表的軟刪除欄位，沒有設定時返回空字串
+func (p *NyaMySQL) softDeleteColumn(table string) string {
+	if p.softDeletes == nil {
+		return ""
+	}
+	p.softDeletes.mu.RLock()
+	defer p.softDeletes.mu.RUnlock()
+	return p.softDeletes.tables[table]
+}
+
+// deleteSQL: 生成刪除語句，表設定了軟刪除且不是 ForceDelete 時改為更新刪除時間
+func (p *NyaMySQL) deleteSQL(table string, where string) string {
+	column := p.softDeleteColumn(table)
+	if column == "" || p.forceDelete {
+		return "delete from `" + table + "` where " + where
+	}
+	return fmt.Sprintf("update `%s` set `%s`=NOW() where (%s) and `%s` is null", table, column, where, column)
+}
+
+// softDeleteWhere: 在 where 後附加軟刪除條件， `name` 為語句中引用該表的名稱（表名或別名），
+// `base` 表示是否為主表（OnlyTrashed 只對主表生效）
+func (p *NyaMySQL) softDeleteWhere(table string, name string, base bool, where string) string {
+	column := p.softDeleteColumn(table)
+	if column == "" || p.trashed == trashedWith {
+		return where
+	}
+	cond := "`" + name + "`.`" + column + "` is null"
+	if base && p.trashed == trashedOnly {
+		cond = "`" + name + "`.`" + column + "` is not null"
+	}
+	if where == "" {
+		return cond
+	}
+	return "(" + where + ") and " + cond
+}
+
+var (
+	// JOIN 語句中的第一個表及其別名，例如 `users` u
+	joinBaseRe = regexp.MustCompile("^\\s*`([^`]+)`(?:\\s+(?i:as\\s+)?`?(\\w+)`?)?")
+	// JOIN 語句中被連接的表及其別名，例如 LEFT JOIN `orders` AS o
+	joinTableRe = regexp.MustCompile("(?i)\\bjoin\\s+`([^`]+)`(?:\\s+(?:as\\s+)?`?(\\w+)`?)?")
+	// LEFT 、RIGHT JOIN 中 JOIN 之前的部分
+	joinOuterRe = regexp.MustCompile("(?i)\\b(?:left|right)(?:\\s+outer)?\\s+$")
+	// 被連接的表之後的 ON
+	joinOnRe = regexp.MustCompile("(?i)^\\s+on\\b")
+	// 一個 JOIN 的開始（含連接類型），用於找到上一個 ON 子句的結尾
+	joinStartRe = regexp.MustCompile("(?i)\\s+(?:(?:natural\\s+)?(?:(?:left|right)(?:\\s+outer)?|inner|cross)\\s+)?(?:straight_)?join\\b")
+	// 不是別名的關鍵字
+	joinKeywords = map[string]bool{"on": true, "using": true, "left": true, "right": true, "inner": true, "outer": true,
+		"cross": true, "natural": true, "join": true, "straight_join": true, "where": true, "as": true}
+)
+
+// softDeleteJOIN: 為 JOIN 語句中所有設定了軟刪除的表附加條件，返回改寫後的 JOIN 語句和 where 。
+// 主表和 INNER JOIN 的表在 where 中過濾；LEFT 、RIGHT JOIN 的表在其 ON 子句中過濾，
+// 使只連接到已刪除行的主表行仍以 NULL 欄位保留。沒有 ON 子句（USING 、NATURAL）時改為連接已排除刪除行的衍生表。
+// RIGHT JOIN 左側的表仍在 where 中過濾。
+func (p *NyaMySQL) softDeleteJOIN(join string, where string) (string, string) {
+	if p.softDeletes == nil || p.trashed == trashedWith {
+		return join, where
+	}
+	if m := joinBaseRe.FindStringSubmatch(join); m != nil {
+		name := m[1]
+		if m[2] != "" && !joinKeywords[strings.ToLower(m[2])] {
+			name = m[2]
+		}
+		where = p.softDeleteWhere(m[1], name, true, where)
+	}
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	starts := joinStartRe.FindAllStringIndex(join, -1)
+	for _, m := range joinTableRe.FindAllStringSubmatchIndex(join, -1) {
+		table, alias := join[m[2]:m[3]], ""
+		if m[4] >= 0 {
+			alias = join[m[4]:m[5]]
+		}
+		keyword := alias != "" && joinKeywords[strings.ToLower(alias)]
+		name := table
+		if alias != "" && !keyword {
+			name = alias
+		}
+		if !joinOuterRe.MatchString(join[:m[0]]) {
+			where = p.softDeleteWhere(table, name, false, where)
+			continue
+		}
+		cond := p.softDeleteWhere(table, name, false, "")
+		if cond == "" {
+			continue
+		}
+		onStart := -1
+		if keyword && strings.EqualFold(alias, "on") {
+			onStart = m[1]
+		} else if !keyword {
+			if loc := joinOnRe.FindStringIndex(join[m[1]:]); loc != nil {
+				onStart = m[1] + loc[1]
+			}
+		}
+		if onStart < 0 {
+			end := m[1]
+			if keyword {
+				end = m[3] + 1 // 表名的反引號之後
+			}
+			derived := "(select * from `" + table + "` where " + p.softDeleteWhere(table, table, false, "") + ") `" + name + "`"
+			edits = append(edits, edit{m[2] - 1, end, derived})
+			continue
+		}
+		// ON 子句到下一個 JOIN 或語句結尾為止
+		onEnd := len(join)
+		for _, s := range starts {
+			if s[0] >= onStart {
+				onEnd = s[0]
+				break
+			}
+		}
+		edits = append(edits, edit{onStart, onEnd, " (" + strings.TrimSpace(join[onStart:onEnd]) + ") and " + cond})
+	}
+	for i := len(edits) - 1; i >= 0; i-- {
+		join = join[:edits[i].start] + edits[i].text + join[edits[i].end:]
+	}
+	return join, where
+}
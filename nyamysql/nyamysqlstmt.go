@@ -0,0 +1,323 @@
+// MySQL 預處理語句快取
+package nyamysql
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// DefaultStmtCacheSize 是 NewC 建立的實例預設快取的預處理語句數量。
+const DefaultStmtCacheSize = 64
+
+// 預處理語句在伺服器上失效的错误代码，遇到時從快取中移除該語句
+var errStmtInvalidCode = []uint16{
+	1243, // Unknown prepared statement handler
+	1615, // Prepared statement needs to be re-prepared
+}
+
+// StmtCacheStats 是預處理語句快取的統計資訊。
+//
+//   - Size: 目前快取的語句數量。
+//   - Capacity: 快取容量，0 表示未啟用。
+//   - Hits: 命中次數。交易中重複使用已綁定到交易的語句也計為命中。
+//   - Misses: 未命中（需要預處理）的次數。
+//   - Evictions: 因容量不足被移除的次數。
+//   - Invalidations: 因連線錯誤或語句失效被移除的次數。
+type StmtCacheStats struct {
+	Size          int
+	Capacity      int
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	Invalidations int64
+}
+
+// stmtCache: 以 (連線, SQL) 為鍵的 LRU 預處理語句快取，在 NyaMySQL 的副本之間共享
+type stmtCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // 最近使用的在前
+	items    map[stmtKey]*list.Element
+	stats    StmtCacheStats
+}
+
+// stmtKey: 快取的鍵，同一條語句在主庫和各副本上分別快取
+type stmtKey struct {
+	db  *sql.DB
+	dbq string
+}
+
+// stmtEntry: 快取中的一條語句。refs 為正在使用的數量，被移除時等到不再使用才關閉。
+type stmtEntry struct {
+	key     stmtKey
+	stmt    *sql.Stmt
+	refs    int
+	removed bool
+}
+
+// txStmtCache: 一個交易中已綁定到交易連線的語句，以 SQL 為鍵，交易結束時關閉
+type txStmtCache struct {
+	mu     sync.Mutex
+	stmts  map[string]*txStmt
+	closed bool
+}
+
+// txStmt: 綁定到交易的語句和其來源的快取語句，交易結束前一直持有來源語句的引用
+type txStmt struct {
+	stmt  *sql.Stmt
+	entry *stmtEntry
+}
+
+// newStmtCache: 建立容量為 capacity 的快取
+func newStmtCache(capacity int) *stmtCache {
+	return &stmtCache{capacity: capacity, ll: list.New(), items: map[stmtKey]*list.Element{}}
+}
+
+// SetStmtCacheSize 設定預處理語句快取的容量，0 表示停用快取（每次執行都重新預處理並關閉）。
+// 縮小容量時最久未使用的語句會被關閉。
+func (p *NyaMySQL) SetStmtCacheSize(n int) {
+	if p.stmts == nil {
+		return
+	}
+	if n < 0 {
+		n = 0
+	}
+	c := p.stmts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capacity = n
+	for c.ll.Len() > c.capacity {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+// StmtCacheStats 返回預處理語句快取的統計資訊，可與 Stats 一起匯出。
+func (p *NyaMySQL) StmtCacheStats() StmtCacheStats {
+	if p.stmts == nil {
+		return StmtCacheStats{}
+	}
+	p.stmts.mu.Lock()
+	defer p.stmts.mu.Unlock()
+	s := p.stmts.stats
+	s.Size = p.stmts.ll.Len()
+	s.Capacity = p.stmts.capacity
+	return s
+}
+
+// prepare: 返回可用於執行 dbq 的預處理語句和使用完畢後必須呼叫的 release 函式。
+// 交易中見 prepareTx ，快取停用時每次重新預處理。
+// release 傳入執行的錯誤，連線錯誤或語句失效時語句會從快取中移除。
+func (p *NyaMySQL) prepare(ctx context.Context, c sqlConn, dbq string) (*sql.Stmt, func(err error), error) {
+	var db *sql.DB
+	switch conn := c.(type) {
+	case *sql.DB:
+		db = conn
+	case *sql.Tx:
+		db = p.db
+	}
+	cache := p.stmts
+	if cache == nil || db == nil || cache.disabled() {
+		stmt, err := c.PrepareContext(ctx, dbq)
+		if err != nil {
+			return nil, nil, err
+		}
+		return stmt, func(error) { stmt.Close() }, nil
+	}
+
+	if tx, ok := c.(*sql.Tx); ok && p.txStmts != nil {
+		return p.prepareTx(ctx, tx, dbq)
+	}
+	entry, err := cache.acquire(ctx, stmtKey{db: db, dbq: dbq})
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry.stmt, func(err error) {
+		cache.release(entry, stmtInvalid(err))
+	}, nil
+}
+
+// prepareTx: 返回綁定到交易的語句。
+// 交易中第一次使用某條語句時以 tx.StmtContext 綁定快取的語句（交易的連線上還沒有預處理過時會在該連線上預處理一次），
+// 之後在同一交易中直接重複使用，交易結束時才關閉，因此熱點語句在交易中也只需要一次往返。
+func (p *NyaMySQL) prepareTx(ctx context.Context, tx *sql.Tx, dbq string) (*sql.Stmt, func(err error), error) {
+	cache, tc := p.stmts, p.txStmts
+	tc.mu.Lock()
+	ts, ok := tc.stmts[dbq]
+	closed := tc.closed
+	tc.mu.Unlock()
+	if closed {
+		return nil, nil, sql.ErrTxDone
+	}
+	if ok {
+		cache.hit()
+	} else {
+		entry, err := cache.acquire(ctx, stmtKey{db: p.db, dbq: dbq})
+		if err != nil {
+			return nil, nil, err
+		}
+		ts = &txStmt{stmt: tx.StmtContext(ctx, entry.stmt), entry: entry}
+		tc.mu.Lock()
+		if tc.closed {
+			tc.mu.Unlock()
+			ts.stmt.Close()
+			cache.release(entry, false)
+			return nil, nil, sql.ErrTxDone
+		}
+		if existing, ok := tc.stmts[dbq]; ok {
+			// 其他 goroutine 已在同一交易中綁定了這條語句
+			tc.mu.Unlock()
+			ts.stmt.Close()
+			cache.release(entry, false)
+			ts = existing
+		} else {
+			tc.stmts[dbq] = ts
+			tc.mu.Unlock()
+		}
+	}
+	return ts.stmt, func(err error) {
+		if stmtInvalid(err) {
+			tc.remove(dbq, ts, cache)
+		}
+	}, nil
+}
+
+// stmtInvalid: 執行錯誤是否表示語句或連線已不可用
+func stmtInvalid(err error) bool {
+	return err != nil && (isConnError(err) || isMySQLError(err, errStmtInvalidCode))
+}
+
+// acquire: 從快取取得語句並增加引用，未命中時預處理並加入快取
+func (c *stmtCache) acquire(ctx context.Context, key stmtKey) (*stmtEntry, error) {
+	if entry := c.get(key); entry != nil {
+		return entry, nil
+	}
+	stmt, err := key.db.PrepareContext(ctx, key.dbq)
+	if err != nil {
+		return nil, err
+	}
+	return c.put(key, stmt), nil
+}
+
+// hit: 記錄一次命中
+func (c *stmtCache) hit() {
+	c.mu.Lock()
+	c.stats.Hits++
+	c.mu.Unlock()
+}
+
+// remove: 語句失效時從交易中移除，並將來源語句從快取中移除
+func (tc *txStmtCache) remove(dbq string, ts *txStmt, cache *stmtCache) {
+	tc.mu.Lock()
+	if tc.stmts[dbq] != ts {
+		tc.mu.Unlock()
+		return
+	}
+	delete(tc.stmts, dbq)
+	tc.mu.Unlock()
+	ts.stmt.Close()
+	cache.release(ts.entry, true)
+}
+
+// close: 交易結束時關閉所有綁定到交易的語句並釋放來源語句的引用
+func (tc *txStmtCache) close(cache *stmtCache) {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	stmts := tc.stmts
+	tc.stmts = nil
+	tc.closed = true
+	tc.mu.Unlock()
+	for _, ts := range stmts {
+		ts.stmt.Close()
+		cache.release(ts.entry, false)
+	}
+}
+
+// disabled: 快取是否停用
+func (c *stmtCache) disabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity == 0
+}
+
+// get: 查詢快取並增加引用，未命中時返回 nil
+func (c *stmtCache) get(key stmtKey) *stmtEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	c.ll.MoveToFront(el)
+	entry := el.Value.(*stmtEntry)
+	entry.refs++
+	return entry
+}
+
+// put: 加入新預處理的語句並增加引用。其他 goroutine 已加入同一條語句時使用已有的並關閉新的。
+func (c *stmtCache) put(key stmtKey, stmt *sql.Stmt) *stmtEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		stmt.Close()
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		return entry
+	}
+	entry := &stmtEntry{key: key, stmt: stmt, refs: 1}
+	if c.capacity == 0 {
+		// 取得語句期間快取被停用，使用完畢後直接關閉
+		entry.removed = true
+		return entry
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.capacity {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+	return entry
+}
+
+// release: 減少引用，invalidate 時從快取中移除。已移除且不再使用的語句會被關閉。
+func (c *stmtCache) release(entry *stmtEntry, invalidate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if invalidate && !entry.removed {
+		c.remove(c.items[entry.key])
+		c.stats.Invalidations++
+		return
+	}
+	if entry.removed && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// remove: 從快取中移除，沒有被使用時立即關閉。呼叫方需持有 c.mu 。
+func (c *stmtCache) remove(el *list.Element) {
+	entry := el.Value.(*stmtEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	entry.removed = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// close: 關閉所有快取的語句
+func (c *stmtCache) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.ll.Len() > 0 {
+		c.remove(c.ll.Back())
+	}
+}
@@ -0,0 +1,211 @@
+// 行程內假驅動
+package nyamysqltest
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+)
+
+// connector: 將所有連線綁定到同一個 Fake
+type connector struct {
+	fake *Fake
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{fake: c.fake}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+// fakeDriver: 只用於滿足 driver.Connector 介面，不支援以 DSN 開啟
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, driver.ErrSkip
+}
+
+// conn: 假連線，所有語句交給 Fake 匹配
+type conn struct {
+	fake *Fake
+}
+
+var (
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.fake.match("BEGIN", nil, true, true); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, err := c.fake.match(query, args, true, false)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return result{}, nil
+	}
+	return result{lastInsertID: e.lastInsertID, rowsAffected: e.rowsAffected}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	e, err := c.fake.match(query, args, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return &rows{}, nil
+	}
+	return &rows{columns: e.columns, data: e.rows}, nil
+}
+
+// CheckNamedValue: 接受任意引數，無法轉換的值原樣傳給 Fake
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value); err == nil {
+		nv.Value = v
+	}
+	return nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return nil
+}
+
+// stmt: 假預處理語句，執行時才匹配
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+// tx: 假交易
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.fake.match("COMMIT", nil, true, true)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.fake.match("ROLLBACK", nil, true, true)
+	return err
+}
+
+// result: 預設的執行結果
+type result struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// rows: 預設的結果集
+type rows struct {
+	columns []string
+	data    [][]interface{}
+	next    int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.data) {
+		return io.EOF
+	}
+	row := r.data[r.next]
+	r.next++
+	for i := range dest {
+		if i >= len(row) {
+			dest[i] = nil
+			continue
+		}
+		v, err := driver.DefaultParameterConverter.ConvertValue(row[i])
+		if err != nil {
+			return err
+		}
+		// 與 MySQL 文字協定一致，字串以 []byte 返回
+		if s, ok := v.(string); ok {
+			v = []byte(s)
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+// namedValues: 將位置引數轉換為 NamedValue
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
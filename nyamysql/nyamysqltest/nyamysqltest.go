@@ -0,0 +1,238 @@
+// Package nyamysqltest 提供不需要 MySQL 伺服器的 nyamysql 測試替身。
+//
+// NewFake 返回的 Fake 內嵌一個以行程內假驅動連線的 *nyamysql.NyaMySQL ，
+// 可以傳給接受 *nyamysql.NyaMySQL 或 nyamysql.Querier 的程式碼。
+// 測試以正規表示式為每條語句預設結果或錯誤，結束時呼叫 ExpectationsWereMet 檢查：
+//
+//	fake := nyamysqltest.NewFake()
+//	defer fake.Close()
+//	fake.ExpectQuery("SELECT .* FROM `users`").WillReturnRows([]string{"id", "name"}, []interface{}{1, "nya"})
+//	fake.ExpectExec("INSERT INTO `orders`").WillReturnError(nyamysqltest.MySQLError(1452, "foreign key"))
+//	...
+//	if err := fake.ExpectationsWereMet(); err != nil {
+//		t.Error(err)
+//	}
+package nyamysqltest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagurazakayashi/libNyaruko_Go/nyamysql"
+)
+
+// AnyArg 用於 WithArgs ，匹配任意引數值。
+var AnyArg = anyArg{}
+
+type anyArg struct{}
+
+// Statement 是一條已執行的語句。
+//
+//   - SQL: 語句。交易控制記錄為 BEGIN 、COMMIT 、ROLLBACK 。
+//   - Args: 語句的引數（已轉換為驅動值）。
+//   - Exec: 是否為不返回結果集的語句。
+//   - Err: 返回給呼叫方的錯誤。
+type Statement struct {
+	SQL  string
+	Args []interface{}
+	Exec bool
+	Err  error
+}
+
+// Expectation 是一條預設的語句及其結果，由 Fake.ExpectExec 或 Fake.ExpectQuery 建立。
+type Expectation struct {
+	pattern      *regexp.Regexp
+	exec         bool
+	args         []interface{}
+	times        int // 允許匹配的次數，-1 表示不限
+	matched      int
+	columns      []string
+	rows         [][]interface{}
+	lastInsertID int64
+	rowsAffected int64
+	err          error
+}
+
+// WithArgs 要求語句的引數與 args 相同，可以使用 AnyArg 匹配任意值。
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	return e
+}
+
+// WillReturnResult 設定 Exec 語句的結果。
+func (e *Expectation) WillReturnResult(lastInsertID int64, rowsAffected int64) *Expectation {
+	e.lastInsertID = lastInsertID
+	e.rowsAffected = rowsAffected
+	return e
+}
+
+// WillReturnRows 設定查詢語句的結果集，每行的值按 columns 的順序排列。
+func (e *Expectation) WillReturnRows(columns []string, rows ...[]interface{}) *Expectation {
+	e.columns = columns
+	e.rows = rows
+	return e
+}
+
+// WillReturnError 設定語句返回的錯誤，可以使用 MySQLError 模擬伺服器錯誤。
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times 設定預設可以被匹配的次數，預設為 1 ，-1 表示不限次數（此時 ExpectationsWereMet 不要求被匹配）。
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// String 返回預設的描述。
+func (e *Expectation) String() string {
+	kind := "query"
+	if e.exec {
+		kind = "exec"
+	}
+	s := fmt.Sprintf("%s %q", kind, e.pattern.String())
+	if e.args != nil {
+		s += fmt.Sprintf(" with args %v", e.args)
+	}
+	return s
+}
+
+// MySQLError 建立一個 MySQL 伺服器錯誤，例如 MySQLError(1452, "...") 模擬外鍵約束失敗，
+// MySQLError(1213, "...") 模擬死鎖。
+func MySQLError(code uint16, message string) *mysql.MySQLError {
+	return &mysql.MySQLError{Number: code, Message: message}
+}
+
+// Fake 是不需要 MySQL 伺服器的 NyaMySQL 測試替身。
+//
+// 沒有匹配任何預設的語句返回錯誤並被記錄為未預期的語句；Lenient 為 true 時改為返回空結果。
+// 交易控制（BEGIN 、COMMIT 、ROLLBACK）總是被允許，也可以用 ExpectExec("^COMMIT$") 等預設錯誤。
+type Fake struct {
+	*nyamysql.NyaMySQL
+	Lenient bool
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	statements   []Statement
+	unexpected   []Statement
+}
+
+// NewFake 建立一個測試替身。重試策略被設為不重試，以免預設的暫時性錯誤被重複匹配。
+func NewFake() *Fake {
+	f := &Fake{}
+	f.NyaMySQL = nyamysql.NewFromDB(sql.OpenDB(&connector{fake: f}), "1000", nil, nyamysql.NYAMYSQL_LOG_LEVEL_ERROR)
+	f.NyaMySQL.SetRetryPolicy(nyamysql.RetryPolicy{})
+	return f
+}
+
+// ExpectExec 預設一條不返回結果集的語句（INSERT 、UPDATE 、DELETE 等），`pattern` 為匹配語句的正規表示式。
+func (f *Fake) ExpectExec(pattern string) *Expectation {
+	return f.expect(pattern, true)
+}
+
+// ExpectQuery 預設一條查詢語句，`pattern` 為匹配語句的正規表示式。
+func (f *Fake) ExpectQuery(pattern string) *Expectation {
+	return f.expect(pattern, false)
+}
+
+func (f *Fake) expect(pattern string, exec bool) *Expectation {
+	e := &Expectation{pattern: regexp.MustCompile(pattern), exec: exec, times: 1}
+	f.mu.Lock()
+	f.expectations = append(f.expectations, e)
+	f.mu.Unlock()
+	return e
+}
+
+// Statements 返回所有已執行的語句，按執行順序排列。
+func (f *Fake) Statements() []Statement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Statement{}, f.statements...)
+}
+
+// Reset 清空預設和已執行的語句。
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	f.expectations = nil
+	f.statements = nil
+	f.unexpected = nil
+	f.mu.Unlock()
+}
+
+// ExpectationsWereMet 檢查所有預設都已被匹配且沒有未預期的語句，否則返回描述所有問題的錯誤。
+func (f *Fake) ExpectationsWereMet() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var problems []string
+	for _, e := range f.expectations {
+		if e.times >= 0 && e.matched < e.times {
+			problems = append(problems, fmt.Sprintf("expected %s to run %d time(s), ran %d", e, e.times, e.matched))
+		}
+	}
+	for _, s := range f.unexpected {
+		problems = append(problems, fmt.Sprintf("unexpected statement %q with args %v", s.SQL, s.Args))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("nyamysqltest: " + strings.Join(problems, "; "))
+}
+
+// match: 記錄語句並返回匹配的預設。沒有匹配時，交易控制語句和 Lenient 模式返回 nil 預設，其他返回錯誤。
+func (f *Fake) match(dbq string, args []driver.NamedValue, exec bool, control bool) (*Expectation, error) {
+	values := make([]interface{}, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stmt := Statement{SQL: dbq, Args: values, Exec: exec}
+	for _, e := range f.expectations {
+		if e.exec != exec || (e.times >= 0 && e.matched >= e.times) || !e.pattern.MatchString(dbq) || !argsMatch(e.args, values) {
+			continue
+		}
+		e.matched++
+		stmt.Err = e.err
+		f.statements = append(f.statements, stmt)
+		return e, e.err
+	}
+	if control || f.Lenient {
+		f.statements = append(f.statements, stmt)
+		return nil, nil
+	}
+	stmt.Err = fmt.Errorf("nyamysqltest: unexpected statement %q", dbq)
+	f.statements = append(f.statements, stmt)
+	f.unexpected = append(f.unexpected, stmt)
+	return nil, stmt.Err
+}
+
+// argsMatch: 比較預設的引數和實際的驅動值，預設的引數先轉換為驅動值
+func argsMatch(want []interface{}, got []interface{}) bool {
+	if want == nil {
+		return true
+	}
+	if len(want) != len(got) {
+		return false
+	}
+	for i, w := range want {
+		if _, ok := w.(anyArg); ok {
+			continue
+		}
+		wv, err := driver.DefaultParameterConverter.ConvertValue(w)
+		if err != nil {
+			wv = w
+		}
+		if !reflect.DeepEqual(wv, got[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package nyamysqltest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagurazakayashi/libNyaruko_Go/nyamysql"
+	"github.com/kagurazakayashi/libNyaruko_Go/nyamysql/nyamysqltest"
+)
+
+func TestFakeQueryAndExec(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.ExpectQuery("select \\* from `users` where id=\\?").WithArgs(1).
+		WillReturnRows([]string{"id", "name"}, []interface{}{1, "nya"})
+	fake.ExpectExec("insert into `users`").WithArgs(2, nyamysqltest.AnyArg).WillReturnResult(2, 1)
+
+	var q nyamysql.Querier = fake
+	data, err := q.QueryData("*", "users", "id=?", "", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["0"]["name"] != "nya" || data["0"]["id"] != "1" {
+		t.Errorf("unexpected rows: %v", data)
+	}
+	rows, id, err := q.AddRecord("users", false, []string{"id", "name"}, 2, "neko")
+	if err != nil || rows != 1 || id != 2 {
+		t.Errorf("AddRecord = %d, %d, %v", rows, id, err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	stmts := fake.Statements()
+	if len(stmts) != 2 || !stmts[1].Exec || stmts[1].Args[1] != "neko" {
+		t.Errorf("unexpected statements: %+v", stmts)
+	}
+}
+
+func TestFakeForeignKeyRetry(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.ExpectExec("insert into `orders`").WithArgs(1, 99).
+		WillReturnError(nyamysqltest.MySQLError(1452, "Cannot add or update a child row: a foreign key constraint fails (FOREIGN KEY (`user_id`))"))
+	fake.ExpectExec("insert into `orders`").WithArgs(1, nil).WillReturnResult(1, 1)
+
+	inserted, _, _, err := fake.AOrUOneRowRecord("orders", []string{"id", "user_id"}, nil, nil, []string{"user_id"}, 1, 99)
+	if err != nil || inserted != 1 {
+		t.Errorf("AOrUOneRowRecord = %d, %v", inserted, err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFakeTx(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.ExpectExec("update `users`").WillReturnResult(0, 1)
+	fake.ExpectExec("delete from `users`").WillReturnError(nyamysqltest.MySQLError(1451, "Cannot delete or update a parent row"))
+
+	err := fake.WithTx(func(tx *nyamysql.Tx) error {
+		if _, err := tx.UpdateRecord("users", "`name`=?", "`id`=?", "nya", 1); err != nil {
+			return err
+		}
+		_, err := tx.DeleteRecord("users", "id", "", 1)
+		return err
+	})
+	var sqlErr *mysql.MySQLError
+	if !errors.As(err, &sqlErr) || sqlErr.Number != 1451 {
+		t.Errorf("WithTx should return the delete error, got %v", err)
+	}
+	var sqls []string
+	for _, s := range fake.Statements() {
+		sqls = append(sqls, strings.Fields(s.SQL)[0])
+	}
+	if got := strings.Join(sqls, ","); got != "BEGIN,update,delete,ROLLBACK" {
+		t.Errorf("unexpected statements: %s", got)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFakeUnexpected(t *testing.T) {
+	fake := nyamysqltest.NewFake()
+	defer fake.Close()
+	fake.ExpectQuery("SELECT 1")
+	if _, err := fake.QueryTable("SELECT 2"); err == nil {
+		t.Error("unexpected statement should fail")
+	}
+	err := fake.ExpectationsWereMet()
+	if err == nil || !strings.Contains(err.Error(), "SELECT 1") || !strings.Contains(err.Error(), "SELECT 2") {
+		t.Errorf("ExpectationsWereMet should report both problems, got %v", err)
+	}
+
+	fake.Reset()
+	fake.Lenient = true
+	if _, err := fake.QueryTable("SELECT 2"); err != nil {
+		t.Errorf("lenient fake should accept any statement: %v", err)
+	}
+	if err := fake.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if _, err := fake.QueryTable("SELECT 3"); errors.Is(err, nyamysql.ErrNotConnected) {
+		t.Error("fake should be connected")
+	}
+}
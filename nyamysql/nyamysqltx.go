@@ -0,0 +1,321 @@
+// MySQL 交易
+package nyamysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Querier 是 NyaMySQL 與 Tx 共同實現的輔助查詢介面。
+// 需要同時支援直接執行和在交易中執行的程式碼可以接受此介面。
+type Querier interface {
+	QueryDataCMD(sql string, value ...[]interface{}) (map[string]map[string]string, error)
+	QueryDataCMDContext(ctx context.Context, sql string, value ...[]interface{}) (map[string]map[string]string, error)
+	QueryDataJOIN(recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryDataJOINContext(ctx context.Context, recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryData(recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryDataContext(ctx context.Context, recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryTable(dbq string, value ...interface{}) (map[string]map[string]string, error)
+	QueryTableContext(ctx context.Context, dbq string, value ...interface{}) (map[string]map[string]string, error)
+	AddRecord(table string, ignore bool, key []string, values ...interface{}) (int64, int64, error)
+	AddRecordContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, int64, error)
+	AddRecordLastInsertId(table string, ignore bool, key []string, values ...interface{}) (int64, error)
+	AddRecordLastInsertIdContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, error)
+	AddOrUpdateRecord(table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error)
+	AddOrUpdateRecordContext(ctx context.Context, table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error)
+	AOrUOneRowRecord(table string, key []string, upkey []string, noupkey []string, retrykey []string, values ...interface{}) (int64, int64, []int64, error)
+	AOrUOneRowRecordContext(ctx context.Context, table string, key []string, upkey []string, noupkey []string, retrykey []string, values ...interface{}) (int64, int64, []int64, error)
+	UpdateRecord(table string, updata string, where string, values ...interface{}) (int64, error)
+	UpdateRecordContext(ctx context.Context, table string, updata string, where string, values ...interface{}) (int64, error)
+	DeleteRecord(table string, key string, and string, values ...interface{}) (int64, error)
+	DeleteRecordContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error)
+	DeleteRecordNoPK(table string, keys []string, values ...interface{}) error
+	DeleteRecordNoPKContext(ctx context.Context, table string, keys []string, values ...interface{}) error
+	Restore(table string, key string, and string, values ...interface{}) (int64, error)
+	RestoreContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error)
+	FreequeryData(sqlstr string, values ...interface{}) (map[string]map[string]string, error)
+	FreequeryDataContext(ctx context.Context, sqlstr string, values ...interface{}) (map[string]map[string]string, error)
+	QueryRows(dbq string, values ...interface{}) (*sql.Rows, error)
+	QueryRowsContext(ctx context.Context, dbq string, values ...interface{}) (*sql.Rows, error)
+	Iterate(ctx context.Context, dbq string, values []interface{}, fn func(row *Row) error) error
+	BulkInsert(ctx context.Context, table string, key []string, values []interface{}, opts BulkOptions) (*BulkResult, error)
+	Paginate(ctx context.Context, q PageQuery, token string) (*Page, error)
+	UpdateVersioned(ctx context.Context, table string, key map[string]interface{}, version int64, changes map[string]interface{}, opts VersionOptions) (int64, error)
+	WithTx(fn func(tx *Tx) error) error
+	WithTxContext(ctx context.Context, fn func(tx *Tx) error) error
+}
+
+var (
+	_ Querier = (*NyaMySQL)(nil)
+	_ Querier = (*Tx)(nil)
+)
+
+// Tx 是一個進行中的 MySQL 交易，提供與 NyaMySQL 相同的輔助方法。
+// 由 Tx.WithTx 建立的巢狀交易使用 SAVEPOINT 實現，其 Commit 和 Rollback
+// 分別對應 RELEASE SAVEPOINT 和 ROLLBACK TO SAVEPOINT 。
+type Tx struct {
+	p         *NyaMySQL // 綁定到此交易的 NyaMySQL 副本
+	tx        *sql.Tx
+	savepoint string // 巢狀交易的儲存點名稱，最外層交易為空
+	depth     int    // 巢狀深度，最外層交易為 0
+}
+
+// Begin 開始一個新的交易。
+//
+// 返回值:
+//   - *Tx: 交易物件，使用完畢後必須呼叫 Commit 或 Rollback 。
+//   - error: 開始交易失敗時返回錯誤。
+func (p *NyaMySQL) Begin() (*Tx, error) {
+	return p.BeginTx(context.Background(), nil)
+}
+
+// BeginTx 同 Begin ，可透過 ctx 取消交易並指定隔離級別等選項。
+//
+// 引數:
+//   - ctx: 上下文，被取消時交易會自動回滾。
+//   - opts: 交易選項，可以為 nil 。
+//
+// 返回值:
+//   - *Tx: 交易物件，使用完畢後必須呼叫 Commit 或 Rollback 。
+//   - error: 開始交易失敗時返回錯誤。
+func (p *NyaMySQL) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if p.tx != nil {
+		return nil, fmt.Errorf("nyamysql: already in a transaction")
+	}
+	sqlTx, err := p.db.BeginTx(ctx, opts)
+	if err != nil {
+		p.logErr("Begin", "BEGIN", nil, err)
+		return nil, err
+	}
+	np := *p
+	np.tx = sqlTx
+	np.txStmts = &txStmtCache{stmts: map[string]*txStmt{}}
+	return &Tx{p: &np, tx: sqlTx}, nil
+}
+
+// WithTx 在交易中執行 fn 。
+// fn 返回 nil 時提交交易，返回錯誤或發生 panic 時回滾交易。
+// 交易因死鎖或鎖等待逾時（ErrDeadlockCode）失敗時，按實例的重試策略回滾後重新執行整個 fn ，
+// 因此 fn 除資料庫操作外不應有其他副作用。
+//
+// 引數:
+//   - fn: 在交易中執行的函式。
+//
+// 返回值:
+//   - error: fn 返回的錯誤，或開始、提交交易時的錯誤。
+func (p *NyaMySQL) WithTx(fn func(tx *Tx) error) error {
+	return p.WithTxContext(context.Background(), fn)
+}
+
+// WithTxContext 同 WithTx ，可透過 ctx 取消交易。
+func (p *NyaMySQL) WithTxContext(ctx context.Context, fn func(tx *Tx) error) error {
+	return p.retryLoop(ctx, IsDeadlockError, func() error {
+		tx, err := p.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		return tx.run(fn)
+	})
+}
+
+// WithTx 在目前交易中建立儲存點並執行 fn 。
+// fn 返回 nil 時釋放儲存點，返回錯誤或發生 panic 時回滾到儲存點，外層交易不受影響。
+//
+// 引數:
+//   - fn: 在巢狀交易中執行的函式。
+//
+// 返回值:
+//   - error: fn 返回的錯誤，或建立、釋放儲存點時的錯誤。
+func (t *Tx) WithTx(fn func(tx *Tx) error) error {
+	return t.WithTxContext(context.Background(), fn)
+}
+
+// WithTxContext 同 WithTx ，可透過 ctx 取消儲存點操作。
+func (t *Tx) WithTxContext(ctx context.Context, fn func(tx *Tx) error) error {
+	depth := t.depth + 1
+	savepoint := fmt.Sprintf("nyamysql_sp_%d", depth)
+	if _, err := t.p.execContext(ctx, "Savepoint", "SAVEPOINT `"+savepoint+"`", nil); err != nil {
+		return err
+	}
+	nested := &Tx{p: t.p, tx: t.tx, savepoint: savepoint, depth: depth}
+	return nested.run(fn)
+}
+
+// run: 執行 fn 並根據結果提交或回滾
+func (t *Tx) run(fn func(tx *Tx) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Rollback()
+			panic(r)
+		}
+	}()
+	if err = fn(t); err != nil {
+		if rbErr := t.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	return t.Commit()
+}
+
+// Commit 提交交易。巢狀交易則釋放對應的儲存點。
+func (t *Tx) Commit() error {
+	if t.savepoint != "" {
+		_, err := t.p.execContext(context.Background(), "Release", "RELEASE SAVEPOINT `"+t.savepoint+"`", nil)
+		return err
+	}
+	err := t.tx.Commit()
+	t.p.txStmts.close(t.p.stmts)
+	if err != nil {
+		t.p.logErr("Commit", "COMMIT", nil, err)
+	}
+	return err
+}
+
+// Rollback 回滾交易。巢狀交易則回滾到對應的儲存點。
+func (t *Tx) Rollback() error {
+	if t.savepoint != "" {
+		_, err := t.p.execContext(context.Background(), "Rollback", "ROLLBACK TO SAVEPOINT `"+t.savepoint+"`", nil)
+		return err
+	}
+	err := t.tx.Rollback()
+	t.p.txStmts.close(t.p.stmts)
+	if err != nil && err != sql.ErrTxDone {
+		t.p.logErr("Rollback", "ROLLBACK", nil, err)
+	}
+	return err
+}
+
+// QueryDataCMD: 同 NyaMySQL.QueryDataCMD ，在交易中執行
+func (t *Tx) QueryDataCMD(sql string, value ...[]interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryDataCMD(sql, value...)
+}
+
+// QueryDataCMDContext: 同 NyaMySQL.QueryDataCMDContext ，在交易中執行
+func (t *Tx) QueryDataCMDContext(ctx context.Context, sql string, value ...[]interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryDataCMDContext(ctx, sql, value...)
+}
+
+// QueryDataJOIN: 同 NyaMySQL.QueryDataJOIN ，在交易中執行
+func (t *Tx) QueryDataJOIN(recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryDataJOIN(recn, join, where, orderby, limit, value...)
+}
+
+// QueryDataJOINContext: 同 NyaMySQL.QueryDataJOINContext ，在交易中執行
+func (t *Tx) QueryDataJOINContext(ctx context.Context, recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryDataJOINContext(ctx, recn, join, where, orderby, limit, value...)
+}
+
+// QueryData: 同 NyaMySQL.QueryData ，在交易中執行
+func (t *Tx) QueryData(recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryData(recn, table, where, orderby, limit, value...)
+}
+
+// QueryDataContext: 同 NyaMySQL.QueryDataContext ，在交易中執行
+func (t *Tx) QueryDataContext(ctx context.Context, recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryDataContext(ctx, recn, table, where, orderby, limit, value...)
+}
+
+// QueryTable: 同 NyaMySQL.QueryTable ，在交易中執行
+func (t *Tx) QueryTable(dbq string, value ...interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryTable(dbq, value...)
+}
+
+// QueryTableContext: 同 NyaMySQL.QueryTableContext ，在交易中執行
+func (t *Tx) QueryTableContext(ctx context.Context, dbq string, value ...interface{}) (map[string]map[string]string, error) {
+	return t.p.QueryTableContext(ctx, dbq, value...)
+}
+
+// AddRecord: 同 NyaMySQL.AddRecord ，在交易中執行
+func (t *Tx) AddRecord(table string, ignore bool, key []string, values ...interface{}) (int64, int64, error) {
+	return t.p.AddRecord(table, ignore, key, values...)
+}
+
+// AddRecordContext: 同 NyaMySQL.AddRecordContext ，在交易中執行
+func (t *Tx) AddRecordContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, int64, error) {
+	return t.p.AddRecordContext(ctx, table, ignore, key, values...)
+}
+
+// AddRecordLastInsertId: 同 NyaMySQL.AddRecordLastInsertId ，在交易中執行
+func (t *Tx) AddRecordLastInsertId(table string, ignore bool, key []string, values ...interface{}) (int64, error) {
+	return t.p.AddRecordLastInsertId(table, ignore, key, values...)
+}
+
+// AddRecordLastInsertIdContext: 同 NyaMySQL.AddRecordLastInsertIdContext ，在交易中執行
+func (t *Tx) AddRecordLastInsertIdContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, error) {
+	return t.p.AddRecordLastInsertIdContext(ctx, table, ignore, key, values...)
+}
+
+// AddOrUpdateRecord: 同 NyaMySQL.AddOrUpdateRecord ，在交易中執行
+func (t *Tx) AddOrUpdateRecord(table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error) {
+	return t.p.AddOrUpdateRecord(table, ignore, key, upkey, values...)
+}
+
+// AddOrUpdateRecordContext: 同 NyaMySQL.AddOrUpdateRecordContext ，在交易中執行
+func (t *Tx) AddOrUpdateRecordContext(ctx context.Context, table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error) {
+	return t.p.AddOrUpdateRecordContext(ctx, table, ignore, key, upkey, values...)
+}
+
+// AOrUOneRowRecord: 同 NyaMySQL.AOrUOneRowRecord ，在交易中執行
+func (t *Tx) AOrUOneRowRecord(table string, key []string, upkey []string, noupkey []string, retrykey []string, values ...interface{}) (int64, int64, []int64, error) {
+	return t.p.AOrUOneRowRecord(table, key, upkey, noupkey, retrykey, values...)
+}
+
+// AOrUOneRowRecordContext: 同 NyaMySQL.AOrUOneRowRecordContext ，在交易中執行
+func (t *Tx) AOrUOneRowRecordContext(ctx context.Context, table string, key []string, upkey []string, noupkey []string, retrykey []string, values ...interface{}) (int64, int64, []int64, error) {
+	return t.p.AOrUOneRowRecordContext(ctx, table, key, upkey, noupkey, retrykey, values...)
+}
+
+// UpdateRecord: 同 NyaMySQL.UpdateRecord ，在交易中執行
+func (t *Tx) UpdateRecord(table string, updata string, where string, values ...interface{}) (int64, error) {
+	return t.p.UpdateRecord(table, updata, where, values...)
+}
+
+// UpdateRecordContext: 同 NyaMySQL.UpdateRecordContext ，在交易中執行
+func (t *Tx) UpdateRecordContext(ctx context.Context, table string, updata string, where string, values ...interface{}) (int64, error) {
+	return t.p.UpdateRecordContext(ctx, table, updata, where, values...)
+}
+
+// DeleteRecord: 同 NyaMySQL.DeleteRecord ，在交易中執行
+func (t *Tx) DeleteRecord(table string, key string, and string, values ...interface{}) (int64, error) {
+	return t.p.DeleteRecord(table, key, and, values...)
+}
+
+// DeleteRecordContext: 同 NyaMySQL.DeleteRecordContext ，在交易中執行
+func (t *Tx) DeleteRecordContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error) {
+	return t.p.DeleteRecordContext(ctx, table, key, and, values...)
+}
+
+// DeleteRecordNoPK: 同 NyaMySQL.DeleteRecordNoPK ，在交易中執行
+func (t *Tx) DeleteRecordNoPK(table string, keys []string, values ...interface{}) error {
+	return t.p.DeleteRecordNoPK(table, keys, values...)
+}
+
+// DeleteRecordNoPKContext: 同 NyaMySQL.DeleteRecordNoPKContext ，在交易中執行
+func (t *Tx) DeleteRecordNoPKContext(ctx context.Context, table string, keys []string, values ...interface{}) error {
+	return t.p.DeleteRecordNoPKContext(ctx, table, keys, values...)
+}
+
+// FreequeryData: 同 NyaMySQL.FreequeryData ，在交易中執行
+func (t *Tx) FreequeryData(sqlstr string, values ...interface{}) (map[string]map[string]string, error) {
+	return t.p.FreequeryData(sqlstr, values...)
+}
+
+// FreequeryDataContext: 同 NyaMySQL.FreequeryDataContext ，在交易中執行
+func (t *Tx) FreequeryDataContext(ctx context.Context, sqlstr string, values ...interface{}) (map[string]map[string]string, error) {
+	return t.p.FreequeryDataContext(ctx, sqlstr, values...)
+}
+
+// QueryRows: 同 NyaMySQL.QueryRows ，在交易中執行
+func (t *Tx) QueryRows(dbq string, values ...interface{}) (*sql.Rows, error) {
+	return t.p.QueryRows(dbq, values...)
+}
+
+// QueryRowsContext: 同 NyaMySQL.QueryRowsContext ，在交易中執行
+func (t *Tx) QueryRowsContext(ctx context.Context, dbq string, values ...interface{}) (*sql.Rows, error) {
+	return t.p.QueryRowsContext(ctx, dbq, values...)
+}
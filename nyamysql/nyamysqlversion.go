@@ -0,0 +1,169 @@
+// MySQL 樂觀鎖
+package nyamysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// DefaultVersionColumn 是 UpdateVersioned 預設使用的版本號欄位。
+const DefaultVersionColumn = "version"
+
+// ErrStaleVersion 表示要更新的行的版本號已被其他請求修改（或該行已不存在），
+// 可以用 errors.Is(err, ErrStaleVersion) 判斷， errors.As 取得 *StaleVersionError 。
+var ErrStaleVersion = errors.New("nyamysql: stale version")
+
+// StaleVersionError 是 UpdateVersioned 沒有更新任何行時返回的錯誤。
+//
+//   - Table: 表名。
+//   - Expected: 呼叫方期望的版本號。
+//   - Current: 重新讀取的目前行，未要求 Reload 或行不存在時為 nil 。
+//   - CurrentVersion: 目前行的版本號，未重新讀取或行不存在時為 -1 。
+//   - NotFound: 重新讀取時該行已不存在。
+type StaleVersionError struct {
+	Table          string
+	Expected       int64
+	Current        map[string]string
+	CurrentVersion int64
+	NotFound       bool
+}
+
+func (e *StaleVersionError) Error() string {
+	switch {
+	case e.NotFound:
+		return fmt.Sprintf("nyamysql: stale version: row in `%s` no longer exists (expected version %d)", e.Table, e.Expected)
+	case e.CurrentVersion >= 0:
+		return fmt.Sprintf("nyamysql: stale version: row in `%s` is at version %d, expected %d", e.Table, e.CurrentVersion, e.Expected)
+	default:
+		return fmt.Sprintf("nyamysql: stale version: row in `%s` is not at version %d", e.Table, e.Expected)
+	}
+}
+
+func (e *StaleVersionError) Is(target error) bool {
+	return target == ErrStaleVersion
+}
+
+// VersionOptions 是 UpdateVersioned 的配置。
+//
+//   - VersionColumn: 版本號欄位，為空時使用 DefaultVersionColumn 。欄位應為整數型別。
+//   - Reload: 更新失敗時重新讀取目前行並放入 StaleVersionError.Current ，方便呼叫方合併後重試。
+type VersionOptions struct {
+	VersionColumn string
+	Reload        bool
+}
+
+// UpdateVersioned 以樂觀鎖更新一行：只在版本號等於 `version` 時更新，並將版本號加 1 。
+//
+// 生成的語句形如 UPDATE `table` SET `a`=?,`version`=`version`+1 WHERE `id`=? AND `version`=? 。
+//
+// 引數:
+//   - ctx: 上下文。
+//   - table: 表名，不需要反引號包裹。
+//   - key: 定位該行的欄位和值，通常是主鍵，例如 map[string]interface{}{"id": 1} 。
+//   - version: 呼叫方讀取該行時的版本號。
+//   - changes: 要更新的欄位和值，不應包含版本號欄位。
+//   - opts: 配置。
+//
+// 返回值:
+//   - int64: 更新後的版本號。
+//   - error: 版本號不符或行不存在時返回 *StaleVersionError ，其他錯誤原樣返回。
+func (p *NyaMySQL) UpdateVersioned(ctx context.Context, table string, key map[string]interface{}, version int64, changes map[string]interface{}, opts VersionOptions) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
+	if len(key) == 0 || len(changes) == 0 {
+		return 0, fmt.Errorf("nyamysql: UpdateVersioned requires key and changes")
+	}
+	versionColumn := opts.VersionColumn
+	if versionColumn == "" {
+		versionColumn = DefaultVersionColumn
+	}
+	if _, ok := changes[versionColumn]; ok {
+		return 0, fmt.Errorf("nyamysql: changes must not contain version column `%s`", versionColumn)
+	}
+
+	set := ""
+	values := make([]interface{}, 0, len(changes)+len(key)+1)
+	for _, col := range sortedKeys(changes) {
+		set += quoteIdent(col) + "=?,"
+		values = append(values, changes[col])
+	}
+	set += quoteIdent(versionColumn) + "=" + quoteIdent(versionColumn) + "+1"
+	where, keyValues := keyCondition(key)
+	values = append(values, keyValues...)
+	values = append(values, version)
+	dbq := "UPDATE " + quoteIdent(table) + " SET " + set + " WHERE " + where + " AND " + quoteIdent(versionColumn) + "=?"
+
+	result, err := p.execContext(ctx, "UpdateVersioned", dbq, values)
+	if err != nil {
+		return 0, err
+	}
+	if num, _ := result.RowsAffected(); num > 0 {
+		return version + 1, nil
+	}
+
+	staleErr := &StaleVersionError{Table: table, Expected: version, CurrentVersion: -1}
+	if opts.Reload {
+		row, err := p.reloadRow(ctx, table, key)
+		if err != nil {
+			return 0, err
+		}
+		if row == nil {
+			staleErr.NotFound = true
+		} else {
+			staleErr.Current = row
+			if v, err := strconv.ParseInt(row[versionColumn], 10, 64); err == nil {
+				staleErr.CurrentVersion = v
+			}
+		}
+	}
+	return 0, staleErr
+}
+
+// UpdateVersioned: 同 NyaMySQL.UpdateVersioned ，在交易中執行
+func (t *Tx) UpdateVersioned(ctx context.Context, table string, key map[string]interface{}, version int64, changes map[string]interface{}, opts VersionOptions) (int64, error) {
+	return t.p.UpdateVersioned(ctx, table, key, version, changes, opts)
+}
+
+// reloadRow: 從主庫重新讀取一行，不存在時返回 nil
+func (p *NyaMySQL) reloadRow(ctx context.Context, table string, key map[string]interface{}) (map[string]string, error) {
+	where, values := keyCondition(key)
+	rows, err := p.queryContext(ctx, "UpdateVersioned", "SELECT * FROM "+quoteIdent(table)+" WHERE "+where+" LIMIT 1", values)
+	if err != nil {
+		return nil, err
+	}
+	var row map[string]string
+	err = iterateRows(rows, func(r *Row) error {
+		var err error
+		row, err = r.Map()
+		return err
+	})
+	return row, err
+}
+
+// keyCondition: 將欄位和值按欄位名排序後生成 `a`=? AND `b`=? 條件
+func keyCondition(key map[string]interface{}) (string, []interface{}) {
+	where := ""
+	values := make([]interface{}, 0, len(key))
+	for _, col := range sortedKeys(key) {
+		if where != "" {
+			where += " AND "
+		}
+		where += quoteIdent(col) + "=?"
+		values = append(values, key[col])
+	}
+	return where, values
+}
+
+// sortedKeys: 返回排序後的鍵，使生成的語句穩定
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
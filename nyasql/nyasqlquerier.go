@@ -0,0 +1,48 @@
+// SQL 資料庫通用介面
+package nyasql
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier 是 nyamysql.NyaMySQL 和 nyasqlite.NyaSQLite 共同的查詢和寫入方法，
+// 服務依賴此介面即可在兩種資料庫之間切換而不需要修改程式碼。
+//
+// 引數和返回值的含義見各實作的說明，兩者的差異：
+//   - AddOrUpdateRecord: MySQL 使用 ON DUPLICATE KEY UPDATE ，更新的行計為 2 行；
+//     SQLite 使用 ON CONFLICT DO UPDATE ，插入和更新的行都計為 1 行。
+//   - QueryData 等方法沒有指定 `limit` 時使用各自配置的預設值。
+//
+// 本套件不依賴任何資料庫驅動，實作方也不需要匯入本套件。
+type Querier interface {
+	QueryData(recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryDataContext(ctx context.Context, recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryDataJOIN(recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryDataJOINContext(ctx context.Context, recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error)
+	QueryTable(dbq string, value ...interface{}) (map[string]map[string]string, error)
+	QueryTableContext(ctx context.Context, dbq string, value ...interface{}) (map[string]map[string]string, error)
+	FreequeryData(sqlstr string, values ...interface{}) (map[string]map[string]string, error)
+	FreequeryDataContext(ctx context.Context, sqlstr string, values ...interface{}) (map[string]map[string]string, error)
+	QueryRows(dbq string, values ...interface{}) (*sql.Rows, error)
+	QueryRowsContext(ctx context.Context, dbq string, values ...interface{}) (*sql.Rows, error)
+	AddRecord(table string, ignore bool, key []string, values ...interface{}) (int64, int64, error)
+	AddRecordContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, int64, error)
+	AddRecordLastInsertId(table string, ignore bool, key []string, values ...interface{}) (int64, error)
+	AddRecordLastInsertIdContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, error)
+	AddOrUpdateRecord(table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error)
+	AddOrUpdateRecordContext(ctx context.Context, table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error)
+	UpdateRecord(table string, updata string, where string, values ...interface{}) (int64, error)
+	UpdateRecordContext(ctx context.Context, table string, updata string, where string, values ...interface{}) (int64, error)
+	DeleteRecord(table string, key string, and string, values ...interface{}) (int64, error)
+	DeleteRecordContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error)
+	DeleteRecordNoPK(table string, keys []string, values ...interface{}) error
+	DeleteRecordNoPKContext(ctx context.Context, table string, keys []string, values ...interface{}) error
+}
+
+// DB 是 Querier 加上連線的生命週期方法。
+type DB interface {
+	Querier
+	Error() error
+	Close()
+}
@@ -6,3 +6,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.25
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/kagurazakayashi/libNyaruko_Go/nyasql v0.0.0-00010101000000-000000000000
+
+replace github.com/kagurazakayashi/libNyaruko_Go/nyasql => ../nyasql
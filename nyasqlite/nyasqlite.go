@@ -2,9 +2,9 @@
 package nyasqlite
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -13,15 +13,45 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// SQLiteConfig 結構體用於配置SQLite資料庫。
+// 該結構體包含以下欄位：
+// - SQLiteVer: 驅動名稱，通常為 "sqlite3" 。
+// - SQLiteFile: 資料庫檔案路徑。
+// - MaxLimit: QueryData 等方法沒有指定 limit 時使用的預設值，為空時不限制。
+// - JournalMode: PRAGMA journal_mode ，例如 "WAL" ，為空時不設定。
+// - Synchronous: PRAGMA synchronous ，例如 "NORMAL" ，為空時不設定。
+// - BusyTimeout: PRAGMA busy_timeout ，資料庫被鎖定時等待的毫秒數，0 時不設定。
+// - ForeignKeys: 是否啟用外鍵約束（PRAGMA foreign_keys）。
+// - CacheSize: PRAGMA cache_size ，正數為頁數，負數為 KiB ，0 時不設定。
+// - MmapSize: PRAGMA mmap_size ，記憶體映射的位元組數，0 時不設定。
+// - ReadOnly: 以唯讀模式開啟（mode=ro）。
+// - Immutable: 以不可變模式開啟（immutable=1），檔案在開啟期間不能被任何程序修改，隱含 ReadOnly 。
+// - MaxReaders: 大於 0 時讀寫分離：寫入使用只有一個連線的連線池，避免並行寫入時出現 database is locked ，
+// 查詢使用最多 MaxReaders 個唯讀連線，建議同時設定 JournalMode 為 "WAL" 。記憶體資料庫和唯讀模式下只限制連線數。
+//
+// PRAGMA 在每個新連線上執行，SQLiteVer 必須為 "sqlite3" 或空。
 type SQLiteConfig struct {
-	SQLiteVer  string `json:"sqlite_ver" yaml:"sqlite_ver"`
-	SQLiteFile string `json:"sqlite_file" yaml:"sqlite_file"`
+	SQLiteVer   string `json:"sqlite_ver" yaml:"sqlite_ver"`
+	SQLiteFile  string `json:"sqlite_file" yaml:"sqlite_file"`
+	MaxLimit    string `json:"sqlite_limit" yaml:"sqlite_limit"`
+	JournalMode string `json:"sqlite_journal_mode" yaml:"sqlite_journal_mode"`
+	Synchronous string `json:"sqlite_synchronous" yaml:"sqlite_synchronous"`
+	BusyTimeout int    `json:"sqlite_busy_timeout" yaml:"sqlite_busy_timeout"`
+	ForeignKeys bool   `json:"sqlite_foreign_keys" yaml:"sqlite_foreign_keys"`
+	CacheSize   int    `json:"sqlite_cache_size" yaml:"sqlite_cache_size"`
+	MmapSize    int64  `json:"sqlite_mmap_size" yaml:"sqlite_mmap_size"`
+	ReadOnly    bool   `json:"sqlite_read_only" yaml:"sqlite_read_only"`
+	Immutable   bool   `json:"sqlite_immutable" yaml:"sqlite_immutable"`
+	MaxReaders  int    `json:"sqlite_max_readers" yaml:"sqlite_max_readers"`
 }
 
 type NyaSQLite NyaSQLiteT
 type NyaSQLiteT struct {
-	db  *sql.DB
-	err error
+	db    *sql.DB // 寫入（沒有讀寫分離時也用於查詢）
+	rdb   *sql.DB // 讀寫分離時的唯讀連線池，否則為 nil
+	limit string
+	err   error
+	debug *log.Logger
 }
 
 // New 函式用於根據配置字串建立一個新的 NyaSQLite 例項。
@@ -57,7 +87,7 @@ func New(configString string, Debug *log.Logger) *NyaSQLite {
 //
 // 引數:
 //   - sqliteConfig: SQLiteConfig 結構體，包含 SQLite 資料庫的版本資訊和檔案路徑。
-//   - Debug: *log.Logger 型別的日誌記錄器，用於除錯日誌輸出（當前程式碼中未使用）。
+//   - Debug: *log.Logger 型別的日誌記錄器，用於輸出執行的語句和錯誤。可以為 nil，表示不記錄除錯資訊。
 //
 // 返回值:
 //   - *NyaSQLite: 返回一個 NyaSQLite 結構體指標，包含資料庫連線和錯誤資訊。
@@ -70,22 +100,33 @@ func NewC(sqliteConfig SQLiteConfig, Debug *log.Logger) *NyaSQLite {
 		return &NyaSQLite{err: err}
 	}
 
-	// 嘗試開啟 SQLite 資料庫連線
-	sqlLiteDB, err := sql.Open(sqliteConfig.SQLiteVer, sqliteConfig.SQLiteFile)
+	// 嘗試開啟 SQLite 資料庫連線，配置無效時返回錯誤
+	sqlLiteDB, readerDB, err := openDBs(sqliteConfig)
 	if err != nil {
 		// 如果連線失敗，返回包含錯誤資訊的 NyaSQLite 例項
 		return &NyaSQLite{err: err}
 	}
+	p := &NyaSQLite{db: sqlLiteDB, rdb: readerDB}
 
-	// 立刻觸發真正的連線與檔案建立
+	// 立刻觸發真正的連線與檔案建立，並確認 PRAGMA 可以執行
 	if err := sqlLiteDB.Ping(); err != nil {
+		p.Close()
 		return &NyaSQLite{err: err}
 	}
+	if readerDB != nil {
+		if err := readerDB.Ping(); err != nil {
+			p.Close()
+			return &NyaSQLite{err: err}
+		}
+	}
 
 	// 返回包含成功連線的 NyaSQLite 例項
 	return &NyaSQLite{
-		db:  sqlLiteDB,
-		err: nil,
+		db:    sqlLiteDB,
+		rdb:   readerDB,
+		limit: sqliteConfig.MaxLimit,
+		err:   nil,
+		debug: Debug,
 	}
 }
 
@@ -98,8 +139,14 @@ func NewC(sqliteConfig SQLiteConfig, Debug *log.Logger) *NyaSQLite {
 // 返回值:
 //   - int64: 最後插入行的ID，如果發生錯誤則返回-1。
 func (p *NyaSQLite) SqlExec(sqlCmd string) int64 {
+	if err := p.check(); err != nil {
+		if p != nil {
+			p.err = err
+		}
+		return -1
+	}
 	var result sql.Result = nil
-	result, p.err = p.db.Exec(sqlCmd)
+	result, p.err = p.execContext(context.Background(), "SqlExec", sqlCmd, nil)
 	if p.err != nil {
 		return -1
 	}
@@ -138,6 +185,9 @@ func (p *NyaSQLite) ErrorString() string {
 // SqliteAddRecord 向指定的SQLite表中插入一條記錄。
 // 該函式根據提供的表名、鍵、值以及可選的多個值字串，構建並執行SQL插入語句。
 //
+// Deprecated: `val` 和 `values` 會被直接拼接到語句中，有 SQL 注入的風險。
+// 請改用以佔位符傳值並支援多行的 AddRecord 。
+//
 // 引數:
 //   - table: 目標表的名稱，插入操作將在此表中進行。
 //   - key: 插入記錄的列名，用於指定插入的欄位。
@@ -148,6 +198,9 @@ func (p *NyaSQLite) ErrorString() string {
 //   - int64: 插入記錄的自增ID，如果插入失敗則返回0。
 //   - error: 如果插入過程中發生錯誤，則返回相應的錯誤資訊。
 func (p *NyaSQLite) SqliteAddRecord(table string, key string, val string, values string) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
 	// 構建SQL插入語句
 	var dbq string = "insert into `" + table + "` (" + key + ")" + "VALUES "
 	if values != "" {
@@ -155,10 +208,9 @@ func (p *NyaSQLite) SqliteAddRecord(table string, key string, val string, values
 	} else {
 		dbq += "(" + val + ")"
 	}
-	fmt.Print(dbq, "\r\n")
 
 	// 執行SQL語句並獲取結果
-	result, err := p.db.Exec(dbq)
+	result, err := p.execContext(context.Background(), "SqliteAddRecord", dbq, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -168,6 +220,11 @@ func (p *NyaSQLite) SqliteAddRecord(table string, key string, val string, values
 	return id, nil
 }
 
+// SetDebug 設定用於輸出執行的語句和錯誤的日誌記錄器，為 nil 時不輸出。
+func (p *NyaSQLite) SetDebug(Debug *log.Logger) {
+	p.debug = Debug
+}
+
 // Close 關閉與 NyaSQLite 例項關聯的資料庫連線。
 // 如果資料庫連線已經關閉或未初始化，則此函式不會執行任何操作。
 // 該方法確保在關閉連線後，將內部資料庫連線指標設定為 nil，以避免重複關閉或空指標引用。
@@ -179,4 +236,8 @@ func (p *NyaSQLite) Close() {
 		// 將資料庫連線指標置為 nil，防止重複關閉
 		p.db = nil
 	}
+	if p.rdb != nil {
+		p.rdb.Close()
+		p.rdb = nil
+	}
 }
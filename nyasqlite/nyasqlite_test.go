@@ -0,0 +1,298 @@
+package nyasqlite_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kagurazakayashi/libNyaruko_Go/nyasql"
+	"github.com/kagurazakayashi/libNyaruko_Go/nyasqlite"
+)
+
+// NyaSQLite 的方法簽名改變時在編譯時發現，而不是在使用者切換資料庫時
+var _ nyasql.DB = (*nyasqlite.NyaSQLite)(nil)
+
+func newTestDB(t *testing.T) *nyasqlite.NyaSQLite {
+	t.Helper()
+	db := nyasqlite.NewC(nyasqlite.SQLiteConfig{SQLiteVer: "sqlite3", SQLiteFile: filepath.Join(t.TempDir(), "test.db")}, nil)
+	if db.Error() != nil {
+		t.Fatal(db.Error())
+	}
+	t.Cleanup(db.Close)
+	if db.SqlExec("CREATE TABLE `users` (`id` INTEGER PRIMARY KEY, `name` TEXT NOT NULL, `age` INTEGER)") < 0 {
+		t.Fatal(db.Error())
+	}
+	return db
+}
+
+func TestQueryHelpers(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	rows, id, err := db.AddRecordContext(ctx, "users", false, []string{"id", "name", "age"}, 1, "nya", 3, 2, "neko", nil)
+	if err != nil || rows != 2 || id != 2 {
+		t.Fatalf("AddRecord = %d, %d, %v", rows, id, err)
+	}
+	if rows, _, err := db.AddRecord("users", true, []string{"id", "name"}, 1, "dup"); err != nil || rows != 0 {
+		t.Errorf("AddRecord ignore = %d, %v", rows, err)
+	}
+	if rows, _, err := db.AddOrUpdateRecord("users", false, []string{"id", "name"}, []string{"name"}, 1, "nyan", 3, "tora"); err != nil || rows != 2 {
+		t.Errorf("AddOrUpdateRecord = %d, %v", rows, err)
+	}
+
+	data, err := db.QueryData("*", "users", "`age` IS NULL OR `id`=?", "`id` ASC", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3 || data["0"]["name"] != "nyan" || data["1"]["age"] != "" || data["2"]["name"] != "tora" {
+		t.Errorf("unexpected QueryData result: %v", data)
+	}
+	data, err = db.QueryDataJOIN("u.`name`, o.`name` AS `other`", []string{"`users` u", " JOIN `users` o ON o.`id`=u.`id`+1"}, "u.`id`=?", "", "", 1)
+	if err != nil || len(data) != 1 || data["0"]["other"] != "neko" {
+		t.Errorf("QueryDataJOIN = %v, %v", data, err)
+	}
+
+	if n, err := db.UpdateRecord("users", "`age`=?", "`id`=?", 5, 2); err != nil || n != 1 {
+		t.Errorf("UpdateRecord = %d, %v", n, err)
+	}
+	if n, err := db.DeleteRecord("users", "id", "and `age`=?", 1, 2, 5); err != nil || n != 1 {
+		t.Errorf("DeleteRecord = %d, %v", n, err)
+	}
+	if err := db.DeleteRecordNoPK("users", []string{"id", "name"}, 1, "nyan"); err != nil {
+		t.Error(err)
+	}
+	data, err = db.QueryTable("SELECT `id` FROM `users`")
+	if err != nil || len(data) != 1 || data["0"]["id"] != "3" {
+		t.Errorf("remaining rows = %v, %v", data, err)
+	}
+
+	var closed *nyasqlite.NyaSQLite
+	if _, err := closed.QueryData("*", "users", "", "", ""); !errors.Is(err, nyasqlite.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	if id := closed.SqlExec("DELETE FROM `users`"); id != -1 {
+		t.Errorf("SqlExec on a nil instance = %d, want -1", id)
+	}
+	db.Close()
+	if id := db.SqlExec("DELETE FROM `users`"); id != -1 || !errors.Is(db.Error(), nyasqlite.ErrNotConnected) {
+		t.Errorf("SqlExec after Close = %d, %v", id, db.Error())
+	}
+}
+
+func TestDebugLog(t *testing.T) {
+	db := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetDebug(log.New(&buf, "", 0))
+	if _, _, err := db.AddRecord("users", false, []string{"id", "name"}, 1, "nya"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QueryData("*", "missing", "", "", ""); err == nil {
+		t.Error("querying a missing table should fail")
+	}
+	out := buf.String()
+	if !strings.Contains(out, "[AddRecord] insert into `users` (`id`,`name`) VALUES ('1','nya')") || !strings.Contains(out, "[QueryTable]query failed") {
+		t.Errorf("unexpected debug log:\n%s", out)
+	}
+}
+
+func TestPragmas(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wal.db")
+	db := nyasqlite.NewC(nyasqlite.SQLiteConfig{
+		SQLiteVer: "sqlite3", SQLiteFile: file, JournalMode: "wal", Synchronous: "NORMAL",
+		BusyTimeout: 5000, ForeignKeys: true, CacheSize: -4096, MaxReaders: 4,
+	}, nil)
+	if db.Error() != nil {
+		t.Fatal(db.Error())
+	}
+	defer db.Close()
+	for pragma, want := range map[string]string{"journal_mode": "wal", "busy_timeout": "5000", "foreign_keys": "1", "synchronous": "1", "cache_size": "-4096", "query_only": "1"} {
+		data, err := db.QueryTable("PRAGMA " + pragma)
+		if err != nil || data["0"][pragma] != want && data["0"]["timeout"] != want {
+			t.Errorf("PRAGMA %s = %v, %v; want %s", pragma, data, err, want)
+		}
+	}
+
+	if db.SqlExec("CREATE TABLE `counter` (`id` INTEGER PRIMARY KEY, `n` INTEGER)") < 0 {
+		t.Fatal(db.Error())
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, _, err := db.AddRecord("counter", false, []string{"n"}, w*100+i); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := db.QueryData("COUNT(*) AS `c`", "counter", "", "", ""); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+	if data, _ := db.QueryData("COUNT(*) AS `c`", "counter", "", "", ""); data["0"]["c"] != "200" {
+		t.Errorf("expected 200 rows, got %v", data)
+	}
+	db.Close()
+
+	ro := nyasqlite.NewC(nyasqlite.SQLiteConfig{SQLiteVer: "sqlite3", SQLiteFile: file, ReadOnly: true}, nil)
+	if ro.Error() != nil {
+		t.Fatal(ro.Error())
+	}
+	defer ro.Close()
+	if _, _, err := ro.AddRecord("counter", false, []string{"n"}, 1); err == nil {
+		t.Error("writing to a read-only database should fail")
+	}
+	if data, err := ro.QueryData("COUNT(*) AS `c`", "counter", "", "", ""); err != nil || data["0"]["c"] != "200" {
+		t.Errorf("read-only QueryData = %v, %v", data, err)
+	}
+
+	for _, bad := range []nyasqlite.SQLiteConfig{
+		{SQLiteVer: "sqlite3", SQLiteFile: file, JournalMode: "fast"},
+		{SQLiteVer: "sqlite3", SQLiteFile: file, Synchronous: "sometimes"},
+		{SQLiteVer: "sqlite3", SQLiteFile: file, BusyTimeout: -1},
+		{SQLiteVer: "other", SQLiteFile: file, ForeignKeys: true},
+	} {
+		if db := nyasqlite.NewC(bad, nil); db.Error() == nil {
+			db.Close()
+			t.Errorf("NewC(%+v) should fail", bad)
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 200; i++ {
+		if _, _, err := db.AddRecord("users", false, []string{"name", "age"}, strings.Repeat("nya", 100), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	backup := filepath.Join(dir, "backup.db")
+	steps := 0
+	err := db.Backup(context.Background(), backup, nyasqlite.BackupOptions{PagesPerStep: 2, OnProgress: func(remaining int, total int) {
+		steps++
+		if remaining > total {
+			t.Errorf("remaining %d > total %d", remaining, total)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps < 2 {
+		t.Errorf("expected several backup steps, got %d", steps)
+	}
+	if err := db.VacuumInto(filepath.Join(dir, "vacuum.db")); err != nil {
+		t.Error(err)
+	}
+	for _, file := range []string{backup, filepath.Join(dir, "vacuum.db")} {
+		copyDB := nyasqlite.NewC(nyasqlite.SQLiteConfig{SQLiteVer: "sqlite3", SQLiteFile: file, ReadOnly: true}, nil)
+		if data, err := copyDB.QueryData("COUNT(*) AS `c`", "users", "", "", ""); err != nil || data["0"]["c"] != "200" {
+			t.Errorf("%s: COUNT = %v, %v", file, data, err)
+		}
+		copyDB.Close()
+	}
+
+	if _, err := db.DeleteRecord("users", "age", "", 0, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Restore(backup); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := db.QueryData("COUNT(*) AS `c`", "users", "", "", ""); err != nil || data["0"]["c"] != "200" {
+		t.Errorf("restored COUNT = %v, %v", data, err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte(strings.Repeat("not a database", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Restore(garbage); err == nil {
+		t.Error("restoring from a corrupt file should fail")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.Backup(ctx, filepath.Join(dir, "canceled.db"), nyasqlite.BackupOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled backup = %v", err)
+	}
+}
+
+func TestFTS(t *testing.T) {
+	db := newTestDB(t)
+	if !db.FTS5Available() {
+		if err := db.CreateFTS("users", nil, nyasqlite.FTSOptions{}); !errors.Is(err, nyasqlite.ErrFTS5Unavailable) {
+			t.Errorf("CreateFTS without FTS5 = %v", err)
+		}
+		t.Skip("FTS5 is not available, run with -tags sqlite_fts5")
+	}
+	if db.SqlExec("ALTER TABLE `users` ADD COLUMN `bio` TEXT") < 0 {
+		t.Fatal(db.Error())
+	}
+	if _, _, err := db.AddRecord("users", false, []string{"id", "name", "bio"}, 1, "nya", "a small black cat who likes fish", 2, "neko", "a dog"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateFTS("users", nil, nyasqlite.FTSOptions{Tokenizer: "porter unicode61", Prefix: []int{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.AddRecord("users", false, []string{"id", "name", "bio"}, 3, "tora", "cats and more cats, a cat house"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdateRecord("users", "`bio`=?", "`id`=?", "a cat in disguise", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := db.Search("users", "cat", nyasqlite.SearchOptions{Columns: []string{"id", "name"}, Snippet: "*", Highlight: "bio", Open: "[", Close: "]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Row["name"] != "tora" || len(results[0].Row) != 2 {
+		t.Fatalf("unexpected Search result: %+v", results)
+	}
+	for i, r := range results {
+		if i > 0 && r.Score < results[i-1].Score {
+			t.Errorf("results are not ordered by score: %+v", results)
+		}
+		if !strings.Contains(r.Snippet, "[cat") || !strings.Contains(r.Highlight, "[cat") {
+			t.Errorf("missing snippet or highlight markers: %+v", r)
+		}
+	}
+	if results, err := db.Search("users", "ca*", nyasqlite.SearchOptions{Where: "`users`.`id`<?", Values: []interface{}{2}}); err != nil || len(results) != 1 || results[0].Row["bio"] == "" {
+		t.Errorf("prefix Search with Where = %+v, %v", results, err)
+	}
+
+	if _, err := db.DeleteRecord("users", "id", "", 3); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := db.Search("users", "house", nyasqlite.SearchOptions{}); err != nil || len(results) != 0 {
+		t.Errorf("deleted row still indexed: %+v, %v", results, err)
+	}
+	if err := db.RebuildFTS("users"); err != nil {
+		t.Error(err)
+	}
+	if err := db.OptimizeFTS("users"); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Search("users", "cat", nyasqlite.SearchOptions{Highlight: "missing"}); err == nil {
+		t.Error("highlighting a column that is not indexed should fail")
+	}
+	if err := db.DropFTS("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.AddRecord("users", false, []string{"id", "name"}, 4, "shiro"); err != nil {
+		t.Errorf("insert after DropFTS = %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// SQLite 線上備份與恢復
+package nyasqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultBackupPages 是 Backup 每一步複製的預設頁數。
+const DefaultBackupPages = 100
+
+// BackupOptions 是 Backup 的配置。
+//
+//   - PagesPerStep: 每一步複製的頁數，0 時使用 DefaultBackupPages ，負數表示一步複製全部。
+//   - Sleep: 每一步之間暫停的時間，讓其他連線有機會寫入。
+//   - OnProgress: 每一步之後呼叫，傳入剩餘頁數和總頁數。
+type BackupOptions struct {
+	PagesPerStep int
+	Sleep        time.Duration
+	OnProgress   func(remaining int, total int)
+}
+
+// Backup 以 SQLite 線上備份 API 將資料庫複製到 `destPath` ，備份期間可以繼續讀寫。
+//
+// 先寫入 `destPath` 旁的臨時檔案，完成後再改名，因此 `destPath` 不會出現不完整的備份。
+// 備份期間其他連線修改了資料庫時，SQLite 會從頭重新開始備份。
+//
+// 引數:
+//   - ctx: 上下文，取消時中止備份並刪除臨時檔案。
+//   - destPath: 備份檔案路徑，已存在時會被覆蓋。
+//   - opts: 配置。
+//
+// 返回值:
+//   - error: 錯誤。
+func (p *NyaSQLite) Backup(ctx context.Context, destPath string, opts BackupOptions) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	pages := opts.PagesPerStep
+	if pages == 0 {
+		pages = DefaultBackupPages
+	}
+	tmpPath := destPath + ".tmp"
+	os.Remove(tmpPath)
+	err := p.rawConn(ctx, p.reader(), func(src *sqlite3.SQLiteConn) error {
+		dest, err := openRaw(tmpPath)
+		if err != nil {
+			return err
+		}
+		defer dest.Close()
+		return copyDatabase(ctx, dest, src, pages, opts)
+	})
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if p.debug != nil {
+		p.debug.Println("[Backup]", destPath)
+	}
+	return os.Rename(tmpPath, destPath)
+}
+
+// VacuumInto: 以 VACUUM INTO 將資料庫壓縮複製到 `path` ，`path` 必須不存在
+//
+//	`path`	string	目標檔案路徑
+//	return	error	錯誤
+func (p *NyaSQLite) VacuumInto(path string) error {
+	return p.VacuumIntoContext(context.Background(), path)
+}
+
+// VacuumIntoContext: 同 VacuumInto ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) VacuumIntoContext(ctx context.Context, path string) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	_, err := p.execContext(ctx, "VacuumInto", "VACUUM INTO ?", []interface{}{path})
+	return err
+}
+
+// Restore: 以 `srcPath` 的內容取代目前的資料庫
+//
+//	先以 PRAGMA quick_check 檢查來源檔案，再以線上備份 API 一次性複製到目前的資料庫。
+//	複製在寫入連線上的一個交易中完成，其他連線只會看到恢復前或恢復後的完整資料，
+//	因此不需要關閉實例，也不會像直接替換檔案一樣破壞已開啟的連線。
+//	`srcPath`	string	來源資料庫檔案，通常是 Backup 或 VacuumInto 的輸出
+//	return		error	錯誤
+func (p *NyaSQLite) Restore(srcPath string) error {
+	return p.RestoreContext(context.Background(), srcPath)
+}
+
+// RestoreContext: 同 Restore ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) RestoreContext(ctx context.Context, srcPath string) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(srcPath); err != nil {
+		return err
+	}
+	src, err := openRaw(readOnlyDSN(srcPath))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := quickCheck(src); err != nil {
+		return fmt.Errorf("nyasqlite: restore source %s: %w", srcPath, err)
+	}
+	if p.debug != nil {
+		p.debug.Println("[Restore]", srcPath)
+	}
+	return p.rawConn(ctx, p.db, func(dest *sqlite3.SQLiteConn) error {
+		return copyDatabase(ctx, dest, src, -1, BackupOptions{})
+	})
+}
+
+// rawConn: 從連線池取出一個連線，以底層的 *sqlite3.SQLiteConn 執行 fn
+func (p *NyaSQLite) rawConn(ctx context.Context, db *sql.DB, fn func(c *sqlite3.SQLiteConn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc interface{}) error {
+		c, ok := dc.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("nyasqlite: backup and restore require the sqlite3 driver")
+		}
+		return fn(c)
+	})
+}
+
+// copyDatabase: 以線上備份 API 將 src 複製到 dest
+func copyDatabase(ctx context.Context, dest *sqlite3.SQLiteConn, src *sqlite3.SQLiteConn, pages int, opts BackupOptions) error {
+	bk, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			bk.Finish()
+			return err
+		}
+		done, err := bk.Step(pages)
+		if err != nil {
+			bk.Finish()
+			return err
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(bk.Remaining(), bk.PageCount())
+		}
+		if done {
+			return bk.Finish()
+		}
+		if opts.Sleep > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(opts.Sleep):
+			}
+		}
+	}
+}
+
+// openRaw: 在連線池之外開啟一個底層連線
+func openRaw(dsn string) (*sqlite3.SQLiteConn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*sqlite3.SQLiteConn), nil
+}
+
+// readOnlyDSN: 以唯讀模式開啟檔案的連線字串
+func readOnlyDSN(path string) string {
+	return SQLiteConfig{SQLiteFile: path, ReadOnly: true}.dsn()
+}
+
+// quickCheck: 執行 PRAGMA quick_check ，結果不是 ok 時返回錯誤
+func quickCheck(c *sqlite3.SQLiteConn) error {
+	rows, err := c.Query("PRAGMA quick_check", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		if err == io.EOF {
+			return errors.New("quick_check returned no result")
+		}
+		return err
+	}
+	if result, _ := dest[0].(string); result != "ok" {
+		return fmt.Errorf("quick_check failed: %v", dest[0])
+	}
+	return nil
+}
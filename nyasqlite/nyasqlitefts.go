@@ -0,0 +1,342 @@
+// SQLite FTS5 全文搜尋
+package nyasqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FTSSuffix 是 CreateFTS 建立的全文索引表名的後綴，例如 `articles` 的索引表為 `articles_fts` 。
+const FTSSuffix = "_fts"
+
+// ErrFTS5Unavailable 表示 SQLite 編譯時沒有啟用 FTS5 。
+// mattn/go-sqlite3 需要以 -tags sqlite_fts5 建置才會啟用。
+var ErrFTS5Unavailable = errors.New("nyasqlite: FTS5 is not available, build with -tags sqlite_fts5")
+
+// FTSOptions 是 CreateFTS 的配置。
+//
+//   - Tokenizer: FTS5 分詞器，例如 "unicode61 remove_diacritics 2" 、"porter unicode61" 、"trigram" ，為空時使用 FTS5 的預設值。
+//   - Prefix: 建立前綴索引的長度，例如 []int{2, 3} ，可以加速 "nya*" 形式的查詢。
+type FTSOptions struct {
+	Tokenizer string
+	Prefix    []int
+}
+
+// SearchOptions 是 Search 的配置。
+//
+//   - Columns: 返回原表的哪些欄位，為空時返回全部。
+//   - Where: 對原表的附加條件，例如 `deleted`=0 ，欄位應以原表名限定。
+//   - Values: Where 中的值。
+//   - Weights: 按索引欄位的順序傳給 bm25 的權重，為空時所有欄位權重為 1 。
+//   - Snippet: 生成摘要的索引欄位，為空時不生成；"*" 表示由 FTS5 選擇最匹配的欄位。
+//   - Highlight: 生成高亮全文的索引欄位，為空時不生成。
+//   - Open, Close: 標記匹配詞的字串，預設為 "<b>" 和 "</b>" 。
+//   - Ellipsis: 摘要被截斷處的字串，預設為 "..." 。
+//   - SnippetTokens: 摘要的最大詞數（1 至 64），預設為 16 。
+//   - Limit: 最多返回的行數，預設為 20 。
+//   - Offset: 跳過的行數。
+type SearchOptions struct {
+	Columns       []string
+	Where         string
+	Values        []interface{}
+	Weights       []float64
+	Snippet       string
+	Highlight     string
+	Open          string
+	Close         string
+	Ellipsis      string
+	SnippetTokens int
+	Limit         int
+	Offset        int
+}
+
+// SearchResult 是 Search 返回的一行。
+//
+//   - Row: 原表的欄位，格式同 QueryData 結果中的一行。
+//   - Score: bm25 分數，越小越相關（結果已按此排序）。
+//   - Snippet: 摘要，沒有要求時為空。
+//   - Highlight: 高亮後的欄位全文，沒有要求時為空。
+type SearchResult struct {
+	Row       map[string]string
+	Score     float64
+	Snippet   string
+	Highlight string
+}
+
+// Search 結果中附加欄位的名稱，避免與原表的欄位衝突
+const (
+	ftsScoreColumn     = "__fts_score"
+	ftsSnippetColumn   = "__fts_snippet"
+	ftsHighlightColumn = "__fts_highlight"
+)
+
+// FTS5Available 返回 SQLite 是否啟用了 FTS5 。
+func (p *NyaSQLite) FTS5Available() bool {
+	if p.check() != nil {
+		return false
+	}
+	data, err := p.QueryTable("SELECT sqlite_compileoption_used('ENABLE_FTS5') AS `fts5`")
+	return err == nil && data["0"]["fts5"] == "1"
+}
+
+// CreateFTS: 為表建立 FTS5 全文索引表（表名加 FTSSuffix），並建立保持同步的觸發器
+//
+//	索引表使用外部內容（content=原表），不重複儲存原文，建立後會索引原表中已有的資料。
+//	原表必須是有 rowid 的普通表（不能是 WITHOUT ROWID）。
+//	`table`		string		原表名，不需要``包裹
+//	`columns`	[]string	需要索引的欄位，為空時索引所有 TEXT 型別的欄位（以 GetTableStructure 取得）
+//	`opts`		FTSOptions	配置
+//	return		error		錯誤，沒有啟用 FTS5 時返回 ErrFTS5Unavailable
+func (p *NyaSQLite) CreateFTS(table string, columns []string, opts FTSOptions) error {
+	return p.CreateFTSContext(context.Background(), table, columns, opts)
+}
+
+// CreateFTSContext: 同 CreateFTS ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) CreateFTSContext(ctx context.Context, table string, columns []string, opts FTSOptions) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	if !p.FTS5Available() {
+		return ErrFTS5Unavailable
+	}
+	if len(columns) == 0 {
+		cols, err := p.GetTableStructure(table)
+		if err != nil {
+			return err
+		}
+		for _, col := range cols {
+			if strings.Contains(strings.ToUpper(col.ColumnType), "TEXT") || strings.Contains(strings.ToUpper(col.ColumnType), "CHAR") {
+				columns = append(columns, col.ColumnName)
+			}
+		}
+		if len(columns) == 0 {
+			return fmt.Errorf("nyasqlite: table `%s` has no text column to index", table)
+		}
+	}
+	fts := table + FTSSuffix
+	args := make([]string, 0, len(columns)+4)
+	newCols := make([]string, len(columns))
+	oldCols := make([]string, len(columns))
+	for i, col := range columns {
+		args = append(args, "`"+col+"`")
+		newCols[i] = "new.`" + col + "`"
+		oldCols[i] = "old.`" + col + "`"
+	}
+	args = append(args, "content="+sqlString(table), "content_rowid='rowid'")
+	if opts.Tokenizer != "" {
+		args = append(args, "tokenize="+sqlString(opts.Tokenizer))
+	}
+	if len(opts.Prefix) > 0 {
+		prefix := make([]string, len(opts.Prefix))
+		for i, n := range opts.Prefix {
+			prefix[i] = strconv.Itoa(n)
+		}
+		args = append(args, "prefix="+sqlString(strings.Join(prefix, " ")))
+	}
+	colList := strings.Join(args[:len(columns)], ",")
+	insertNew := fmt.Sprintf("INSERT INTO `%s`(rowid,%s) VALUES (new.rowid,%s);", fts, colList, strings.Join(newCols, ","))
+	deleteOld := fmt.Sprintf("INSERT INTO `%s`(`%s`,rowid,%s) VALUES ('delete',old.rowid,%s);", fts, fts, colList, strings.Join(oldCols, ","))
+	stmts := []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE `%s` USING fts5(%s)", fts, strings.Join(args, ",")),
+		fmt.Sprintf("CREATE TRIGGER `%s_ai` AFTER INSERT ON `%s` BEGIN %s END", fts, table, insertNew),
+		fmt.Sprintf("CREATE TRIGGER `%s_ad` AFTER DELETE ON `%s` BEGIN %s END", fts, table, deleteOld),
+		fmt.Sprintf("CREATE TRIGGER `%s_au` AFTER UPDATE ON `%s` BEGIN %s %s END", fts, table, deleteOld, insertNew),
+		fmt.Sprintf("INSERT INTO `%s`(`%s`) VALUES ('rebuild')", fts, fts),
+	}
+	return p.execAll(ctx, "CreateFTS", stmts)
+}
+
+// DropFTS: 刪除 CreateFTS 建立的全文索引表和觸發器，原表不受影響
+//
+//	`table`	string	原表名，不需要``包裹
+//	return	error	錯誤
+func (p *NyaSQLite) DropFTS(table string) error {
+	return p.DropFTSContext(context.Background(), table)
+}
+
+// DropFTSContext: 同 DropFTS ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) DropFTSContext(ctx context.Context, table string) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	fts := table + FTSSuffix
+	return p.execAll(ctx, "DropFTS", []string{
+		"DROP TRIGGER IF EXISTS `" + fts + "_ai`",
+		"DROP TRIGGER IF EXISTS `" + fts + "_ad`",
+		"DROP TRIGGER IF EXISTS `" + fts + "_au`",
+		"DROP TABLE IF EXISTS `" + fts + "`",
+	})
+}
+
+// RebuildFTS: 從原表重新建立全文索引，用於索引與原表不一致（例如觸發器建立前寫入的資料）時
+//
+//	`table`	string	原表名，不需要``包裹
+//	return	error	錯誤
+func (p *NyaSQLite) RebuildFTS(table string) error {
+	return p.RebuildFTSContext(context.Background(), table)
+}
+
+// RebuildFTSContext: 同 RebuildFTS ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) RebuildFTSContext(ctx context.Context, table string) error {
+	return p.ftsCommand(ctx, "RebuildFTS", table, "rebuild")
+}
+
+// OptimizeFTS: 合併全文索引的所有 b-tree ，減少索引大小並加快查詢，適合在大量寫入後執行
+//
+//	`table`	string	原表名，不需要``包裹
+//	return	error	錯誤
+func (p *NyaSQLite) OptimizeFTS(table string) error {
+	return p.OptimizeFTSContext(context.Background(), table)
+}
+
+// OptimizeFTSContext: 同 OptimizeFTS ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) OptimizeFTSContext(ctx context.Context, table string) error {
+	return p.ftsCommand(ctx, "OptimizeFTS", table, "optimize")
+}
+
+// ftsCommand: 執行 FTS5 的特殊指令
+func (p *NyaSQLite) ftsCommand(ctx context.Context, tag string, table string, command string) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	fts := table + FTSSuffix
+	_, err := p.execContext(ctx, tag, fmt.Sprintf("INSERT INTO `%s`(`%s`) VALUES (?)", fts, fts), []interface{}{command})
+	return err
+}
+
+// Search: 在 CreateFTS 建立的全文索引中搜尋，按 bm25 分數排序返回原表的行
+//
+//	`table`	string		原表名，不需要``包裹
+//	`query`	string		FTS5 查詢語法，例如 "nya AND neko" 、"nya*" 、"title: nya"
+//	`opts`	SearchOptions	配置
+//	return	[]SearchResult	結果，最相關的在最前面
+//	return	error		錯誤
+func (p *NyaSQLite) Search(table string, query string, opts SearchOptions) ([]SearchResult, error) {
+	return p.SearchContext(context.Background(), table, query, opts)
+}
+
+// SearchContext: 同 Search ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) SearchContext(ctx context.Context, table string, query string, opts SearchOptions) ([]SearchResult, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	fts := table + FTSSuffix
+	open, closing, ellipsis := opts.Open, opts.Close, opts.Ellipsis
+	if open == "" && closing == "" {
+		open, closing = "<b>", "</b>"
+	}
+	if ellipsis == "" {
+		ellipsis = "..."
+	}
+	tokens := opts.SnippetTokens
+	if tokens <= 0 {
+		tokens = 16
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	var indexed []string
+	if opts.Snippet != "" || opts.Highlight != "" {
+		cols, err := p.GetTableStructure(fts)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range cols {
+			indexed = append(indexed, col.ColumnName)
+		}
+	}
+	columnIndex := func(name string) (int, error) {
+		if name == "*" {
+			return -1, nil
+		}
+		for i, col := range indexed {
+			if col == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("nyasqlite: column `%s` is not indexed in `%s`", name, fts)
+	}
+
+	recn := "`" + table + "`.*"
+	if len(opts.Columns) > 0 {
+		recn = "`" + table + "`.`" + strings.Join(opts.Columns, "`,`"+table+"`.`") + "`"
+	}
+	bm25 := "bm25(`" + fts + "`"
+	for _, w := range opts.Weights {
+		bm25 += "," + strconv.FormatFloat(w, 'g', -1, 64)
+	}
+	bm25 += ")"
+	dbq := "SELECT " + recn + "," + bm25 + " AS `" + ftsScoreColumn + "`"
+	var values []interface{}
+	if opts.Snippet != "" {
+		idx, err := columnIndex(opts.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		dbq += fmt.Sprintf(",snippet(`%s`,%d,?,?,?,%d) AS `%s`", fts, idx, tokens, ftsSnippetColumn)
+		values = append(values, open, closing, ellipsis)
+	}
+	if opts.Highlight != "" {
+		idx, err := columnIndex(opts.Highlight)
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("nyasqlite: invalid highlight column `%s`", opts.Highlight)
+		}
+		dbq += fmt.Sprintf(",highlight(`%s`,%d,?,?) AS `%s`", fts, idx, ftsHighlightColumn)
+		values = append(values, open, closing)
+	}
+	dbq += fmt.Sprintf(" FROM `%s` JOIN `%s` ON `%s`.rowid=`%s`.rowid WHERE `%s` MATCH ?", fts, table, table, fts, fts)
+	values = append(values, query)
+	if opts.Where != "" {
+		dbq += " AND (" + opts.Where + ")"
+		values = append(values, opts.Values...)
+	}
+	dbq += " ORDER BY `" + ftsScoreColumn + "` LIMIT ? OFFSET ?"
+	values = append(values, limit, opts.Offset)
+
+	rows, err := p.queryContext(ctx, "Search", dbq, values)
+	if err != nil {
+		return nil, err
+	}
+	data, err := handleQD(rows)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(data))
+	for i := range results {
+		row := data[strconv.Itoa(i)]
+		score, _ := strconv.ParseFloat(row[ftsScoreColumn], 64)
+		results[i] = SearchResult{Score: score, Snippet: row[ftsSnippetColumn], Highlight: row[ftsHighlightColumn]}
+		delete(row, ftsScoreColumn)
+		delete(row, ftsSnippetColumn)
+		delete(row, ftsHighlightColumn)
+		results[i].Row = row
+	}
+	return results, nil
+}
+
+// execAll: 在一個交易中依次執行多條語句
+func (p *NyaSQLite) execAll(ctx context.Context, tag string, stmts []string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, dbq := range stmts {
+		p.logSQL(tag, dbq, nil)
+		if _, err := tx.ExecContext(ctx, dbq); err != nil {
+			p.logErr(tag, err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqlString: 以單引號包裹字串並轉義其中的單引號
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
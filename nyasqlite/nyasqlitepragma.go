@@ -0,0 +1,169 @@
+// SQLite 連線設定
+package nyasqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// 允許的 journal_mode 和 synchronous 值
+var (
+	journalModes = map[string]bool{"DELETE": true, "TRUNCATE": true, "PERSIST": true, "MEMORY": true, "WAL": true, "OFF": true}
+	syncModes    = map[string]bool{"OFF": true, "NORMAL": true, "FULL": true, "EXTRA": true, "0": true, "1": true, "2": true, "3": true}
+)
+
+// connector: 以 ConnectHook 在每個新連線上套用 PRAGMA ，不需要全域註冊驅動
+type connector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// tuned: 是否設定了需要自訂連線的選項
+func (c SQLiteConfig) tuned() bool {
+	return c.JournalMode != "" || c.Synchronous != "" || c.BusyTimeout != 0 || c.ForeignKeys ||
+		c.CacheSize != 0 || c.MmapSize != 0 || c.ReadOnly || c.Immutable || c.MaxReaders != 0
+}
+
+// inMemory: 是否為記憶體資料庫，記憶體資料庫的每個連線是不同的資料庫，不能讀寫分離
+func (c SQLiteConfig) inMemory() bool {
+	return c.SQLiteFile == ":memory:" || strings.Contains(c.SQLiteFile, "mode=memory")
+}
+
+// pragmas: 驗證配置並生成每個新連線上執行的 PRAGMA 。
+// `reader` 為 true 時生成唯讀連線的 PRAGMA ：不設定 journal_mode（需要寫入權限）並設定 query_only 。
+func (c SQLiteConfig) pragmas(reader bool) ([]string, error) {
+	var pragmas []string
+	if c.BusyTimeout < 0 || c.MmapSize < 0 || c.MaxReaders < 0 {
+		return nil, fmt.Errorf("nyasqlite: sqlite_busy_timeout, sqlite_mmap_size and sqlite_max_readers must not be negative")
+	}
+	// busy_timeout 放在最前面，使之後的 PRAGMA 遇到鎖時也會等待
+	if c.BusyTimeout > 0 {
+		pragmas = append(pragmas, "PRAGMA busy_timeout = "+strconv.Itoa(c.BusyTimeout))
+	}
+	if c.JournalMode != "" {
+		mode := strings.ToUpper(c.JournalMode)
+		if !journalModes[mode] {
+			return nil, fmt.Errorf("nyasqlite: invalid sqlite_journal_mode %q", c.JournalMode)
+		}
+		if !reader && !c.ReadOnly && !c.Immutable {
+			pragmas = append(pragmas, "PRAGMA journal_mode = "+mode)
+		}
+	}
+	if c.Synchronous != "" {
+		mode := strings.ToUpper(c.Synchronous)
+		if !syncModes[mode] {
+			return nil, fmt.Errorf("nyasqlite: invalid sqlite_synchronous %q", c.Synchronous)
+		}
+		pragmas = append(pragmas, "PRAGMA synchronous = "+mode)
+	}
+	if c.ForeignKeys {
+		pragmas = append(pragmas, "PRAGMA foreign_keys = ON")
+	}
+	if c.CacheSize != 0 {
+		pragmas = append(pragmas, "PRAGMA cache_size = "+strconv.Itoa(c.CacheSize))
+	}
+	if c.MmapSize > 0 {
+		pragmas = append(pragmas, "PRAGMA mmap_size = "+strconv.FormatInt(c.MmapSize, 10))
+	}
+	if reader {
+		pragmas = append(pragmas, "PRAGMA query_only = ON")
+	}
+	return pragmas, nil
+}
+
+// dsn: 生成連線字串，唯讀或不可變時使用 URI 參數
+func (c SQLiteConfig) dsn() string {
+	if !c.ReadOnly && !c.Immutable {
+		return c.SQLiteFile
+	}
+	dsn := c.SQLiteFile
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(dsn)
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	dsn += sep + "mode=ro"
+	if c.Immutable {
+		dsn += "&immutable=1"
+	}
+	return dsn
+}
+
+// openDBs: 根據配置開啟資料庫連線。
+// 設定了 MaxReaders 時返回只有一個連線的寫入連線池和最多 MaxReaders 個連線的唯讀連線池，否則唯讀連線池為 nil 。
+func openDBs(c SQLiteConfig) (*sql.DB, *sql.DB, error) {
+	if c.SQLiteVer != "" && c.SQLiteVer != "sqlite3" {
+		if c.tuned() {
+			return nil, nil, fmt.Errorf("nyasqlite: connection options require the sqlite3 driver, got %q", c.SQLiteVer)
+		}
+		db, err := sql.Open(c.SQLiteVer, c.SQLiteFile)
+		return db, nil, err
+	}
+	split := c.MaxReaders > 0 && !c.ReadOnly && !c.Immutable && !c.inMemory()
+	db, err := openDB(c, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !split {
+		if c.MaxReaders > 0 {
+			db.SetMaxOpenConns(c.MaxReaders)
+		}
+		return db, nil, nil
+	}
+	db.SetMaxOpenConns(1)
+	// 先建立寫入連線，使 journal_mode 等在唯讀連線開啟前生效
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	rdb, err := openDB(c, true)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	rdb.SetMaxOpenConns(c.MaxReaders)
+	return db, rdb, nil
+}
+
+// openDB: 開啟一個在每個新連線上執行 PRAGMA 的連線池
+func openDB(c SQLiteConfig, reader bool) (*sql.DB, error) {
+	pragmas, err := c.pragmas(reader)
+	if err != nil {
+		return nil, err
+	}
+	d := &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, pragma := range pragmas {
+				if _, err := conn.Exec(pragma, nil); err != nil {
+					return fmt.Errorf("nyasqlite: %s: %w", pragma, err)
+				}
+			}
+			return nil
+		},
+	}
+	return sql.OpenDB(&connector{driver: d, dsn: c.dsn()}), nil
+}
+
+// reader: 返回查詢使用的連線池，沒有讀寫分離時為寫入連線池
+func (p *NyaSQLite) reader() *sql.DB {
+	if p.rdb != nil {
+		return p.rdb
+	}
+	return p.db
+}
@@ -0,0 +1,429 @@
+// SQLite 查詢與寫入
+package nyasqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotConnected 表示實例沒有可用的資料庫連線（nil 實例、建立失敗或已關閉）。
+var ErrNotConnected = errors.New("nyasqlite: not connected")
+
+// check: 確認實例可用
+func (p *NyaSQLite) check() error {
+	if p == nil || p.db == nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// QueryDataCMD: 依次執行以 ; 分隔的多條語句，返回最後一條語句的查詢結果
+//
+//	`sql`	string		以 ; 分隔的SQL語句
+//	`value`	...[]interface{}	每條語句中的值
+//	return 結構同 QueryData
+func (p *NyaSQLite) QueryDataCMD(sql string, value ...[]interface{}) (map[string]map[string]string, error) {
+	return p.QueryDataCMDContext(context.Background(), sql, value...)
+}
+
+// QueryDataCMDContext: 同 QueryDataCMD ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) QueryDataCMDContext(ctx context.Context, sql string, value ...[]interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
+	}
+	sqls := strings.Split(sql, ";")
+	for i, v := range sqls {
+		var val []interface{}
+		if i < len(value) {
+			val = value[i]
+		}
+		// 最後一條語句的結果作為查詢結果返回，其餘語句只執行
+		if i+1 == len(sqls) {
+			// 前面的語句可能建立了只在寫入連線上可見的狀態（例如臨時表），因此多條語句時在寫入連線池上查詢
+			db := p.reader()
+			if len(sqls) > 1 {
+				db = p.db
+			}
+			query, err := p.queryOn(ctx, db, "QueryDataCMD", v, val)
+			if err != nil {
+				return map[string]map[string]string{}, err
+			}
+			return handleQD(query)
+		}
+		if _, err := p.execContext(ctx, "QueryDataCMD", v, val); err != nil {
+			return map[string]map[string]string{}, err
+		}
+	}
+	return map[string]map[string]string{}, fmt.Errorf("query is null")
+}
+
+// QueryDataJOIN: 從SQLite資料庫中以JOIN語句查詢
+//
+//	所有關鍵字除*以外需要用``包裹
+//	`recn`		string		查詢語句的返回。全部：*，指定：`id`
+//	`join`		[]string	JOIN語句，會依次連接
+//	`where`		string		where語句部分，最前方不需要填寫where，例：`id`=?
+//	`orderby`	string		排序，例：`id` ASC/DESC
+//	`limit`		string		分頁，例：1,10 ，為空時使用配置的 sqlite_limit ，沒有配置時不限制
+//	`value`		interface{}	查詢條件的值
+//	return 結構同 QueryData
+func (p *NyaSQLite) QueryDataJOIN(recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	return p.QueryDataJOINContext(context.Background(), recn, join, where, orderby, limit, value...)
+}
+
+// QueryDataJOINContext: 同 QueryDataJOIN ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) QueryDataJOINContext(ctx context.Context, recn string, join []string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
+	}
+	dbq := "select " + recn + " from " + strings.Join(join, "")
+	return p.QueryTableContext(ctx, p.selectTail(dbq, where, orderby, limit), value...)
+}
+
+// QueryData: 從SQLite資料庫中查詢
+//
+//	所有關鍵字除*以外需要用``包裹
+//	`recn`		string		查詢語句的返回。全部：*，指定：`id`
+//	`table`		string		從哪個表中查詢，不需要``包裹
+//	`where`		string		where語句部分，最前方不需要填寫where，例：`id`=?
+//	`orderby`	string		排序，例：`id` ASC/DESC
+//	`limit`		string		分頁，例：1,10 ，為空時使用配置的 sqlite_limit ，沒有配置時不限制
+//	`value`		interface{}	查詢條件的值
+//	return map 和 error 物件，結構為：
+//	{
+//	    "0":{"id":"1","name":"1"},
+//	    "1":{"id":"2","name":"2"}
+//	}
+func (p *NyaSQLite) QueryData(recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	return p.QueryDataContext(context.Background(), recn, table, where, orderby, limit, value...)
+}
+
+// QueryDataContext: 同 QueryData ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) QueryDataContext(ctx context.Context, recn string, table string, where string, orderby string, limit string, value ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
+	}
+	dbq := "select " + recn + " from `" + table + "`"
+	return p.QueryTableContext(ctx, p.selectTail(dbq, where, orderby, limit), value...)
+}
+
+// selectTail: 在查詢語句後加上 where 、order by 和 limit
+func (p *NyaSQLite) selectTail(dbq string, where string, orderby string, limit string) string {
+	if where != "" {
+		dbq += " where " + where
+	}
+	if orderby != "" {
+		dbq += " ORDER BY " + orderby
+	}
+	if limit == "" {
+		limit = p.limit
+	}
+	if limit != "" {
+		dbq += " limit " + limit
+	}
+	return dbq
+}
+
+// QueryTable: 執行完整的查詢語句
+//
+//	`dbq`		string		完整的SQL查詢語句
+//	`value`		interface{}	查詢條件的值
+//	return 結構同 QueryData
+func (p *NyaSQLite) QueryTable(dbq string, value ...interface{}) (map[string]map[string]string, error) {
+	return p.QueryTableContext(context.Background(), dbq, value...)
+}
+
+// QueryTableContext: 同 QueryTable ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) QueryTableContext(ctx context.Context, dbq string, value ...interface{}) (map[string]map[string]string, error) {
+	if err := p.check(); err != nil {
+		return map[string]map[string]string{}, err
+	}
+	query, err := p.queryContext(ctx, "QueryTable", dbq, value)
+	if err != nil {
+		return map[string]map[string]string{}, err
+	}
+	return handleQD(query)
+}
+
+// FreequeryData: 執行任意查詢語句，同 QueryTable
+//
+//	`sqlstr`	string		需要執行的SQL語句
+//	`values`	...interface{}	語句中的值
+//	return 結構同 QueryData
+func (p *NyaSQLite) FreequeryData(sqlstr string, values ...interface{}) (map[string]map[string]string, error) {
+	return p.FreequeryDataContext(context.Background(), sqlstr, values...)
+}
+
+// FreequeryDataContext: 同 FreequeryData ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) FreequeryDataContext(ctx context.Context, sqlstr string, values ...interface{}) (map[string]map[string]string, error) {
+	return p.QueryTableContext(ctx, sqlstr, values...)
+}
+
+// QueryRows: 執行查詢並直接返回結果集，供需要自行掃描結果的呼叫方使用
+//
+//	`dbq`		string		完整的SQL查詢語句
+//	`values`	...interface{}	查詢條件的值
+//	return *sql.Rows 和 error，結果集使用完畢後必須關閉
+func (p *NyaSQLite) QueryRows(dbq string, values ...interface{}) (*sql.Rows, error) {
+	return p.QueryRowsContext(context.Background(), dbq, values...)
+}
+
+// QueryRowsContext: 同 QueryRows ，可透過 `ctx` 取消查詢或設定逾時
+func (p *NyaSQLite) QueryRowsContext(ctx context.Context, dbq string, values ...interface{}) (*sql.Rows, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return p.queryContext(ctx, "QueryRows", dbq, values)
+}
+
+// AddRecord: 向SQLite資料庫中新增，`values` 的數量為 `key` 的整數倍時一次新增多行
+//
+//	`table`		string		新增到哪個表，不需要``包裹
+//	`ignore`	bool		是否忽略重复（INSERT OR IGNORE）
+//	`key`		[]string	需要新增的字段
+//	`values`	...interface{}	新增的值，按行依次排列
+//	return int64,int64 和 error 物件，返回受影响行数,最后插入的 ID
+func (p *NyaSQLite) AddRecord(table string, ignore bool, key []string, values ...interface{}) (int64, int64, error) {
+	return p.AddRecordContext(context.Background(), table, ignore, key, values...)
+}
+
+// AddRecordContext: 同 AddRecord ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) AddRecordContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, int64, error) {
+	return p.AddOrUpdateRecordContext(ctx, table, ignore, key, []string{}, values...)
+}
+
+// AddRecordLastInsertId: 同 AddRecord ，只返回最後插入的 ID
+func (p *NyaSQLite) AddRecordLastInsertId(table string, ignore bool, key []string, values ...interface{}) (int64, error) {
+	return p.AddRecordLastInsertIdContext(context.Background(), table, ignore, key, values...)
+}
+
+// AddRecordLastInsertIdContext: 同 AddRecordLastInsertId ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) AddRecordLastInsertIdContext(ctx context.Context, table string, ignore bool, key []string, values ...interface{}) (int64, error) {
+	_, id, err := p.AddOrUpdateRecordContext(ctx, table, ignore, key, []string{}, values...)
+	return id, err
+}
+
+// AddOrUpdateRecord: 向SQLite資料庫中新增，衝突時更新（INSERT ... ON CONFLICT DO UPDATE）
+//
+//	`table`		string		新增到哪個表，不需要``包裹
+//	`ignore`	bool		是否忽略重复，`upkey` 為空時有效
+//	`key`		[]string	需要新增的字段
+//	`upkey`		[]string	衝突時需要更新的字段，為空時同 AddRecord
+//	`values`	...interface{}	新增的值，按行依次排列
+//	return int64,int64 和 error 物件，返回受影响行数（插入和更新都計為 1 行）,最后插入的 ID
+func (p *NyaSQLite) AddOrUpdateRecord(table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error) {
+	return p.AddOrUpdateRecordContext(context.Background(), table, ignore, key, upkey, values...)
+}
+
+// AddOrUpdateRecordContext: 同 AddOrUpdateRecord ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) AddOrUpdateRecordContext(ctx context.Context, table string, ignore bool, key []string, upkey []string, values ...interface{}) (int64, int64, error) {
+	if err := p.check(); err != nil {
+		return 0, 0, err
+	}
+	if len(key) == 0 || len(values)%len(key) != 0 {
+		return 0, 0, fmt.Errorf("'values'内容数量与'key'不符")
+	}
+	tag := "AddRecord"
+	if len(upkey) != 0 {
+		tag = "AddOrUpdateRecord"
+	}
+	result, err := p.execContext(ctx, tag, insertSQL(table, ignore, key, upkey, len(values)/len(key)), values)
+	if err != nil {
+		return 0, 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	lastInsertId, err := result.LastInsertId()
+	if err != nil {
+		return rowsAffected, 0, err
+	}
+	return rowsAffected, lastInsertId, nil
+}
+
+// insertSQL: 生成插入 `rows` 行的語句，`upkey` 不為空時衝突時更新這些欄位
+func insertSQL(table string, ignore bool, key []string, upkey []string, rows int) string {
+	dbq := "insert"
+	if ignore && len(upkey) == 0 {
+		dbq += " OR IGNORE"
+	}
+	dbq += " into `" + table + "` (`" + strings.Join(key, "`,`") + "`) VALUES "
+	row := "(?" + strings.Repeat(",?", len(key)-1) + ")"
+	dbq += row + strings.Repeat(","+row, rows-1)
+	if len(upkey) != 0 {
+		sets := make([]string, len(upkey))
+		for i, v := range upkey {
+			sets[i] = "`" + v + "`=excluded.`" + v + "`"
+		}
+		dbq += " ON CONFLICT DO UPDATE SET " + strings.Join(sets, ",")
+	}
+	return dbq
+}
+
+// UpdateRecord: 修改SQLite資料庫中的資料
+//
+//	`table`		string		修改哪個表，不需要``包裹
+//	`updata`	string		需要修改的值，需要以,分割，例:`name`=?,`age`=?
+//	`where`		string		需要修改行的條件，例:`id`=?
+//	`values`	...interface{}	`updata` 和 `where` 中的值
+//	return int64 和 error，返回更新的行数
+func (p *NyaSQLite) UpdateRecord(table string, updata string, where string, values ...interface{}) (int64, error) {
+	return p.UpdateRecordContext(context.Background(), table, updata, where, values...)
+}
+
+// UpdateRecordContext: 同 UpdateRecord ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) UpdateRecordContext(ctx context.Context, table string, updata string, where string, values ...interface{}) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
+	dbq := "update `" + table + "` set " + updata
+	if where != "" {
+		dbq += " where " + where
+	}
+	result, err := p.execContext(ctx, "UpdateRecord", dbq, values)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteRecord: 刪除SQLite資料庫中的資料
+//
+//	`table`		string		從哪個表中刪除，不需要``包裹
+//	`key`		string		根據哪個關鍵字刪除
+//	`and`		string		附加條件，例:and `name`=?
+//	`values`	...interface{}	刪除條件的值，多於一個時以 in 刪除多行，`and` 中的值放在最後
+//	return		int64		刪除的行数
+//	return		error		錯誤
+func (p *NyaSQLite) DeleteRecord(table string, key string, and string, values ...interface{}) (int64, error) {
+	return p.DeleteRecordContext(context.Background(), table, key, and, values...)
+}
+
+// DeleteRecordContext: 同 DeleteRecord ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) DeleteRecordContext(ctx context.Context, table string, key string, and string, values ...interface{}) (int64, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
+	dbq := "delete from `" + table + "` where `" + key + "`"
+	if n := len(values) - strings.Count(and, "?"); len(values) > 1 && n > 0 {
+		dbq += " in (?" + strings.Repeat(",?", n-1) + ") " + and
+	} else {
+		dbq += "=? " + and
+	}
+	result, err := p.execContext(ctx, "DeleteRecord", dbq, values)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteRecordNoPK: 根據多個欄位刪除SQLite資料庫中的資料
+//
+//	`table`		string		從哪個表中刪除，不需要``包裹
+//	`keys`		[]string	根據哪些關鍵字刪除
+//	`values`	...interface{}	刪除條件的值，數量為 `keys` 的整數倍時刪除多行
+func (p *NyaSQLite) DeleteRecordNoPK(table string, keys []string, values ...interface{}) error {
+	return p.DeleteRecordNoPKContext(context.Background(), table, keys, values...)
+}
+
+// DeleteRecordNoPKContext: 同 DeleteRecordNoPK ，可透過 `ctx` 取消操作或設定逾時
+func (p *NyaSQLite) DeleteRecordNoPKContext(ctx context.Context, table string, keys []string, values ...interface{}) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	if len(keys) == 0 || len(values)%len(keys) != 0 {
+		return fmt.Errorf("'values'内容数量与'keys'不符")
+	}
+	conds := make([]string, len(keys))
+	for i, k := range keys {
+		conds[i] = "`" + k + "`=?"
+	}
+	row := "(" + strings.Join(conds, " and ") + ")"
+	dbq := "delete from `" + table + "` where (" + row + strings.Repeat(" or "+row, len(values)/len(keys)-1) + ")"
+	_, err := p.execContext(ctx, "DeleteRecordNoPK", dbq, values)
+	return err
+}
+
+// queryContext: 執行查詢語句，讀寫分離時使用唯讀連線池，`tag` 用於日誌
+func (p *NyaSQLite) queryContext(ctx context.Context, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	return p.queryOn(ctx, p.reader(), tag, dbq, values)
+}
+
+// queryOn: 在指定的連線池上執行查詢語句
+func (p *NyaSQLite) queryOn(ctx context.Context, db *sql.DB, tag string, dbq string, values []interface{}) (*sql.Rows, error) {
+	p.logSQL(tag, dbq, values)
+	rows, err := db.QueryContext(ctx, dbq, values...)
+	if err != nil {
+		p.logErr(tag, err)
+	}
+	return rows, err
+}
+
+// execContext: 執行寫入語句，`tag` 用於日誌
+func (p *NyaSQLite) execContext(ctx context.Context, tag string, dbq string, values []interface{}) (sql.Result, error) {
+	p.logSQL(tag, dbq, values)
+	result, err := p.db.ExecContext(ctx, dbq, values...)
+	if err != nil {
+		p.logErr(tag, err)
+	}
+	return result, err
+}
+
+// logSQL: 設定了日誌記錄器時輸出即將執行的語句
+func (p *NyaSQLite) logSQL(tag string, dbq string, values []interface{}) {
+	if p.debug != nil {
+		p.debug.Println("["+tag+"]", dbPrintStr(dbq, values))
+	}
+}
+
+// logErr: 設定了日誌記錄器時輸出執行失敗的錯誤
+func (p *NyaSQLite) logErr(tag string, err error) {
+	if p.debug != nil {
+		p.debug.Printf("[%s]query failed, error:[%v]", tag, err)
+	}
+}
+
+// dbPrintStr: 將SQL語句中的 ? 替換成實際值，過長的字串會被截斷
+func dbPrintStr(dbStr string, values []interface{}) string {
+	for _, v := range values {
+		if val, ok := v.(string); ok && len(val) > 50 {
+			v = val[:50] + "..."
+		}
+		dbStr = strings.Replace(dbStr, "?", fmt.Sprintf("'%v'", v), 1)
+	}
+	return dbStr
+}
+
+// handleQD: 將結果集轉換為 序號 -> 列名 -> 值 ，NULL 值為空字串
+func handleQD(query *sql.Rows) (map[string]map[string]string, error) {
+	defer query.Close()
+	cols, err := query.Columns()
+	if err != nil {
+		return map[string]map[string]string{}, err
+	}
+	values := make([]sql.RawBytes, len(cols))
+	scans := make([]interface{}, len(cols))
+	for i := range values {
+		scans[i] = &values[i]
+	}
+	results := map[string]map[string]string{}
+	for i := 0; query.Next(); i++ {
+		if err := query.Scan(scans...); err != nil {
+			return map[string]map[string]string{}, err
+		}
+		row := make(map[string]string, len(cols))
+		for k, v := range values {
+			row[cols[k]] = string(v)
+		}
+		results[strconv.Itoa(i)] = row
+	}
+	if err := query.Err(); err != nil {
+		return map[string]map[string]string{}, err
+	}
+	return results, nil
+}